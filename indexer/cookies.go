@@ -0,0 +1,179 @@
+package indexer
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shibukawa/configdir"
+)
+
+const (
+	configFileName = "config.json"
+	cookiesDirName = "cookies"
+)
+
+// CookieStore persists the session cookies of an indexer between runs
+type CookieStore interface {
+	Load(site string) ([]*http.Cookie, error)
+	Save(site string, cookies []*http.Cookie) error
+}
+
+type fileCookieStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileCookieStore returns a CookieStore that keeps cookies in a folder next to config.json
+func NewFileCookieStore() (CookieStore, error) {
+	cd, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+
+	folder := cd.QueryFolderContainsFile(configFileName)
+	if folder == nil {
+		folder = cd.QueryFolders(configdir.Global)[0]
+	}
+
+	return &fileCookieStore{dir: filepath.Join(folder.Path, cookiesDirName)}, nil
+}
+
+func (fs *fileCookieStore) path(site string) string {
+	return filepath.Join(fs.dir, site+".json")
+}
+
+func (fs *fileCookieStore) Load(site string) ([]*http.Cookie, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	data, err := ioutil.ReadFile(fs.path(site))
+	if os.IsNotExist(err) {
+		return []*http.Cookie{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stored []*http.Cookie
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+
+	cookies := []*http.Cookie{}
+	for _, cookie := range stored {
+		if !isExpired(cookie, time.Now()) {
+			cookies = append(cookies, cookie)
+		}
+	}
+
+	return cookies, nil
+}
+
+func (fs *fileCookieStore) Save(site string, cookies []*http.Cookie) error {
+	data, err := json.MarshalIndent(cookies, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := os.MkdirAll(fs.dir, 0700); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a concurrent load never sees a partial file
+	tmp, err := ioutil.TempFile(fs.dir, site+".json.")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), fs.path(site))
+}
+
+func isExpired(cookie *http.Cookie, now time.Time) bool {
+	return !cookie.Expires.IsZero() && !cookie.Expires.After(now)
+}
+
+// cookieRecorder wraps a cookie jar to keep the full attributes of the cookies that are
+// set, as a jar only returns the name and value of its cookies
+type cookieRecorder struct {
+	http.CookieJar
+	mu      sync.Mutex
+	cookies map[string]*http.Cookie
+}
+
+func newCookieRecorder(jar http.CookieJar) *cookieRecorder {
+	return &cookieRecorder{CookieJar: jar, cookies: map[string]*http.Cookie{}}
+}
+
+func (c *cookieRecorder) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	c.CookieJar.SetCookies(u, cookies)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	for _, cookie := range cookies {
+		recorded := *cookie
+		key := recorded.Name + ";" + recorded.Domain + ";" + recorded.Path
+
+		// max-age is relative to when the cookie was set, so record it as an expiry time
+		if recorded.MaxAge > 0 {
+			recorded.Expires = now.Add(time.Duration(recorded.MaxAge) * time.Second)
+			recorded.MaxAge = 0
+		}
+
+		if recorded.MaxAge < 0 || isExpired(&recorded, now) {
+			delete(c.cookies, key)
+			continue
+		}
+
+		recorded.Raw = ""
+		c.cookies[key] = &recorded
+	}
+}
+
+// recorded returns the cookies that haven't expired, ordered by name
+func (c *cookieRecorder) recorded() []*http.Cookie {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	cookies := []*http.Cookie{}
+
+	for key, cookie := range c.cookies {
+		if isExpired(cookie, now) {
+			delete(c.cookies, key)
+			continue
+		}
+		cookies = append(cookies, cookie)
+	}
+
+	sort.Sort(cookiesByName(cookies))
+	return cookies
+}
+
+type cookiesByName []*http.Cookie
+
+func (c cookiesByName) Len() int           { return len(c) }
+func (c cookiesByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c cookiesByName) Less(i, j int) bool { return c[i].Name < c[j].Name }
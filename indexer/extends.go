@@ -0,0 +1,99 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+const maxExtendsDepth = 10
+
+// shallowMergePaths are maps whose entries are replaced as a whole by an extending
+// definition rather than merged key by key
+var shallowMergePaths = map[string]bool{
+	"search.fields": true,
+}
+
+// resolveExtends merges the source of a definition on top of the definition it extends,
+// which is loaded via the lookup func
+func resolveExtends(src []byte, lookup func(key string) ([]byte, error)) ([]byte, error) {
+	return resolveExtendsDepth(src, lookup, 0)
+}
+
+func resolveExtendsDepth(src []byte, lookup func(key string) ([]byte, error), depth int) ([]byte, error) {
+	var child map[interface{}]interface{}
+	if err := yaml.Unmarshal(src, &child); err != nil {
+		return nil, err
+	}
+
+	baseKey, ok := child["extends"].(string)
+	if !ok {
+		return src, nil
+	}
+
+	if depth >= maxExtendsDepth {
+		return nil, errors.New("Too many levels of extends, definitions might extend each other")
+	}
+
+	baseSrc, err := lookup(baseKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load extended definition %q: %s", baseKey, err.Error())
+	}
+
+	baseSrc, err = resolveExtendsDepth(baseSrc, lookup, depth+1)
+	if err != nil {
+		return nil, err
+	}
+
+	var base map[interface{}]interface{}
+	if err := yaml.Unmarshal(baseSrc, &base); err != nil {
+		return nil, err
+	}
+
+	merged := mergeYAML(base, child, "")
+	delete(merged, "extends")
+
+	return yaml.Marshal(merged)
+}
+
+// mergeYAML deep merges override into base, a null value in override removes the key
+func mergeYAML(base, override map[interface{}]interface{}, path string) map[interface{}]interface{} {
+	result := map[interface{}]interface{}{}
+	for k, v := range base {
+		result[k] = v
+	}
+
+	for k, v := range override {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+
+		keyPath := joinPath(path, fmt.Sprintf("%v", k))
+		baseMap, baseIsMap := result[k].(map[interface{}]interface{})
+		overrideMap, overrideIsMap := v.(map[interface{}]interface{})
+
+		switch {
+		case baseIsMap && overrideIsMap && shallowMergePaths[keyPath]:
+			merged := map[interface{}]interface{}{}
+			for mk, mv := range baseMap {
+				merged[mk] = mv
+			}
+			for mk, mv := range overrideMap {
+				if mv == nil {
+					delete(merged, mk)
+				} else {
+					merged[mk] = mv
+				}
+			}
+			result[k] = merged
+		case baseIsMap && overrideIsMap:
+			result[k] = mergeYAML(baseMap, overrideMap, keyPath)
+		default:
+			result[k] = v
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,114 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/cardigann/cardigann/torznab"
+)
+
+const exampleBaseDefinition = `
+---
+  site: gazelle
+  caps:
+    categories:
+      1: Audio
+    modes:
+      search: [q]
+
+  login:
+    path: /login.php
+    inputs:
+      username: "{{ .Config.username }}"
+      password: "{{ .Config.password }}"
+      keeplogged: 1
+
+  search:
+    path: torrents.php
+    inputs:
+      searchstr: "{{ .Query.Keywords }}"
+    rows:
+      selector: tr.torrent
+    fields:
+      title:
+        selector: a.title
+        filters:
+          - name: regexp
+            args: "^(.+)$"
+      download:
+        selector: a.download
+        attribute: href
+`
+
+const exampleExtendingDefinition = `
+---
+  extends: _gazelle
+  site: mygazelle
+  name: My Gazelle
+
+  caps:
+    categories:
+      2: TV
+
+  login:
+    inputs:
+      keeplogged: ~
+
+  search:
+    inputs:
+      order_by: time
+    fields:
+      title:
+        selector: a.torrent-title
+`
+
+func TestResolveExtends(t *testing.T) {
+	src, err := resolveExtends([]byte(exampleExtendingDefinition), func(key string) ([]byte, error) {
+		if key != "_gazelle" {
+			return nil, ErrUnknownIndexer
+		}
+		return []byte(exampleBaseDefinition), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def, err := ParseDefinition(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if def.Site != "mygazelle" || def.Name != "My Gazelle" {
+		t.Fatalf("Expected site and name from the extending definition, got %q and %q", def.Site, def.Name)
+	}
+
+	if def.Login.Path != "/login.php" {
+		t.Fatalf("Expected login path to be inherited, got %q", def.Login.Path)
+	}
+
+	if _, ok := def.Login.Inputs["keeplogged"]; ok {
+		t.Fatal("Expected keeplogged input to be removed")
+	}
+
+	if def.Search.Inputs["searchstr"].Value == "" || def.Search.Inputs["order_by"].Value != "time" {
+		t.Fatalf("Expected search inputs to be merged, got %v", def.Search.Inputs)
+	}
+
+	if len(def.Search.Fields["title"].Filters) != 0 || def.Search.Fields["title"].Selector != "a.torrent-title" {
+		t.Fatalf("Expected title field to be replaced, got %#v", def.Search.Fields["title"])
+	}
+
+	if def.Search.Fields["download"].Selector != "a.download" {
+		t.Fatal("Expected download field to be inherited")
+	}
+
+	cats := torznab.Capabilities(def.Capabilities).Categories
+	if cats["1"][0] != torznab.CategoryAudio || cats["2"][0] != torznab.CategoryTV {
+		t.Fatalf("Expected categories to be merged, got %v", cats)
+	}
+
+	if _, err = resolveExtends([]byte("extends: llamas"), func(key string) ([]byte, error) {
+		return []byte("extends: llamas"), nil
+	}); err == nil {
+		t.Fatal("Expected an error for definitions extending each other")
+	}
+}
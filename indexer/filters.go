@@ -3,13 +3,16 @@ package indexer
 import (
 	"errors"
 	"fmt"
+	"html"
 	"net/url"
-	"os"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"text/scanner"
 	"time"
+	"unicode"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/cardigann/cardigann/torznab"
@@ -19,66 +22,404 @@ const (
 	filterTimeFormat = time.RFC1123Z
 )
 
-var (
-	filterLogger          logrus.FieldLogger
-	filterCategoryMapping torznab.CategoryMapping
+// FilterContext carries the state of the runner that is applying a filter
+type FilterContext struct {
+	Logger     logrus.FieldLogger
+	Categories torznab.CategoryMapping
+	Location   *time.Location
+}
+
+// now returns the current time in the context's location, if it has one
+func (ctx FilterContext) now() time.Time {
+	if ctx.Location != nil {
+		return time.Now().In(ctx.Location)
+	}
+	return time.Now()
+}
+
+func (ctx FilterContext) logger() logrus.FieldLogger {
+	if ctx.Logger == nil {
+		return logrus.StandardLogger()
+	}
+	return ctx.Logger
+}
+
+// FilterArgType is the type of value a filter argument must have
+type FilterArgType int
+
+const (
+	FilterArgString FilterArgType = iota
+	FilterArgInt
 )
 
-func init() {
-	filterLogger = logrus.New()
-	filterLogger.(*logrus.Logger).Level = logrus.DebugLevel
-	filterLogger.(*logrus.Logger).Out = os.Stderr
+func (t FilterArgType) String() string {
+	switch t {
+	case FilterArgString:
+		return "a string"
+	case FilterArgInt:
+		return "an int"
+	}
+	return "an unknown"
+}
+
+// FilterArg declares an argument accepted by a filter
+type FilterArg struct {
+	Type     FilterArgType
+	Optional bool
 }
 
-func invokeFilter(name string, args interface{}, value string) (string, error) {
-	switch name {
-	case "querystring":
-		param, ok := args.(string)
-		if !ok {
-			return "", fmt.Errorf("Filter %q requires a string argument", name)
+// FilterArgs are the decoded arguments of a filter, in the order they were declared
+type FilterArgs []interface{}
+
+// Len returns the number of arguments that were provided
+func (a FilterArgs) Len() int {
+	return len(a)
+}
+
+// String returns the string argument at idx, or an empty string if it wasn't provided
+func (a FilterArgs) String(idx int) string {
+	if idx < len(a) {
+		if s, ok := a[idx].(string); ok {
+			return s
 		}
-		return filterQueryString(param, value)
+	}
+	return ""
+}
 
-	case "dateparse":
-		format, ok := args.(string)
-		if !ok {
-			return "", fmt.Errorf("Filter %q requires a string argument", name)
+// Int returns the int argument at idx, or zero if it wasn't provided
+func (a FilterArgs) Int(idx int) int {
+	if idx < len(a) {
+		if i, ok := a[idx].(int); ok {
+			return i
 		}
-		return filterDateParse(format, value)
+	}
+	return 0
+}
+
+// Filter transforms the text extracted by a selector. Args declares the arguments the
+// filter takes, which are decoded and type checked before Validate and Apply are called.
+// Arguments are given as a list, or as a scalar when only the first one is provided.
+type Filter struct {
+	Args     []FilterArg
+	Validate func(args FilterArgs) error
+	Apply    func(ctx FilterContext, args FilterArgs, value string) (string, error)
+}
+
+var registeredFilters = struct {
+	sync.RWMutex
+	filters map[string]Filter
+}{filters: map[string]Filter{}}
+
+// RegisterFilter makes a filter available to definitions under the given name. It
+// panics if the name is already registered or the filter has no Apply func.
+func RegisterFilter(name string, f Filter) {
+	registeredFilters.Lock()
+	defer registeredFilters.Unlock()
+
+	if f.Apply == nil {
+		panic("indexer: RegisterFilter " + name + " has no Apply func")
+	}
+	if _, exists := registeredFilters.filters[name]; exists {
+		panic("indexer: RegisterFilter called twice for filter " + name)
+	}
+
+	registeredFilters.filters[name] = f
+}
+
+// unregisterFilter removes a registered filter, for tests that register their own
+func unregisterFilter(name string) {
+	registeredFilters.Lock()
+	defer registeredFilters.Unlock()
+
+	delete(registeredFilters.filters, name)
+}
+
+func lookupFilter(name string) (Filter, bool) {
+	registeredFilters.RLock()
+	defer registeredFilters.RUnlock()
+
+	f, ok := registeredFilters.filters[name]
+	return f, ok
+}
+
+func (f Filter) describeArgs() string {
+	types := []string{}
+	allOptional := true
 
-	case "regexp":
-		pattern, ok := args.(string)
-		if !ok {
-			return "", fmt.Errorf("Filter %q requires a string argument", name)
+	for _, arg := range f.Args {
+		allOptional = allOptional && arg.Optional
+	}
+
+	for _, arg := range f.Args {
+		if arg.Optional && !allOptional {
+			types = append(types, "an optional "+strings.TrimPrefix(strings.TrimPrefix(arg.Type.String(), "an "), "a "))
+		} else {
+			types = append(types, arg.Type.String())
 		}
-		return filterRegexp(pattern, value)
+	}
+
+	desc := strings.Join(types, " and ") + " argument"
+	if allOptional {
+		desc += " or none"
+	}
 
-	case "split":
-		sep, ok := (args.([]interface{}))[0].(string)
-		if !ok {
-			return "", fmt.Errorf("Filter %q requires a string argument at idx 0", name)
+	return desc
+}
+
+// decodeArgs converts the args of a filter from a definition into FilterArgs
+func (f Filter) decodeArgs(name string, args interface{}) (FilterArgs, error) {
+	if len(f.Args) == 0 {
+		if args != nil {
+			return nil, fmt.Errorf("Filter %q doesn't take any arguments", name)
 		}
-		pos, ok := (args.([]interface{}))[1].(int)
-		if !ok {
-			return "", fmt.Errorf("Filter %q requires an int argument at idx 1", name)
+		return FilterArgs{}, nil
+	}
+
+	var argSlice []interface{}
+
+	if args == nil {
+		argSlice = []interface{}{}
+	} else if len(f.Args) == 1 {
+		argSlice = []interface{}{args}
+	} else if s, ok := args.([]interface{}); ok {
+		argSlice = s
+	} else {
+		argSlice = []interface{}{args}
+	}
+
+	required := 0
+	for _, arg := range f.Args {
+		if !arg.Optional {
+			required++
 		}
-		return filterSplit(sep, pos, value)
+	}
+
+	if len(argSlice) < required || len(argSlice) > len(f.Args) {
+		return nil, fmt.Errorf("Filter %q requires %s", name, f.describeArgs())
+	}
 
-	case "timeago":
-		return filterTimeAgo(value, time.Now())
+	for idx, val := range argSlice {
+		var ok bool
 
-	case "reltime":
-		format, ok := args.(string)
-		if !ok {
-			return "", fmt.Errorf("Filter %q requires a string argument", name)
+		switch f.Args[idx].Type {
+		case FilterArgString:
+			_, ok = val.(string)
+		case FilterArgInt:
+			_, ok = val.(int)
 		}
-		return filterRelTime(value, format, time.Now())
 
-	case "mapcats":
-		return filterMapCategory(value)
+		if !ok && len(f.Args) == 1 {
+			return nil, fmt.Errorf("Filter %q requires %s", name, f.describeArgs())
+		} else if !ok {
+			return nil, fmt.Errorf("Filter %q requires %s argument at idx %d", name, f.Args[idx].Type, idx)
+		}
 	}
 
-	return "", errors.New("Unknown filter " + name)
+	return FilterArgs(argSlice), nil
+}
+
+func invokeFilter(ctx FilterContext, name string, args interface{}, value string) (string, error) {
+	f, ok := lookupFilter(name)
+	if !ok {
+		return "", errors.New("Unknown filter " + name)
+	}
+
+	decoded, err := f.decodeArgs(name, args)
+	if err != nil {
+		return "", err
+	}
+
+	return f.Apply(ctx, decoded, value)
+}
+
+// validateFilter checks that a filter is registered and that its arguments are valid
+func validateFilter(name string, args interface{}) error {
+	f, ok := lookupFilter(name)
+	if !ok {
+		return errors.New("Unknown filter " + name)
+	}
+
+	decoded, err := f.decodeArgs(name, args)
+	if err != nil {
+		return err
+	}
+
+	if f.Validate != nil {
+		if err = f.Validate(decoded); err != nil {
+			return fmt.Errorf("Filter %q %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+var filterBlockSliceType = reflect.TypeOf([]filterBlock{})
+
+// validateFilters walks a parsed definition and validates every filter block in it
+func validateFilters(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			return validateFilters(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if err := validateFilters(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if v.Type() == filterBlockSliceType {
+			for _, f := range v.Interface().([]filterBlock) {
+				if err := validateFilter(f.Name, f.Args); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := validateFilters(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if err := validateFilters(v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validatePatternArg(args FilterArgs) error {
+	if _, err := regexp.Compile(args.String(0)); err != nil {
+		return fmt.Errorf("has an invalid pattern: %s", err.Error())
+	}
+	return nil
+}
+
+var (
+	noArgs        = []FilterArg{}
+	stringArg     = []FilterArg{{Type: FilterArgString}}
+	optStringArg  = []FilterArg{{Type: FilterArgString, Optional: true}}
+	twoStringArgs = []FilterArg{{Type: FilterArgString}, {Type: FilterArgString}}
+)
+
+func init() {
+	RegisterFilter("querystring", Filter{Args: stringArg,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterQueryString(args.String(0), value)
+		}})
+
+	RegisterFilter("dateparse", Filter{
+		Args: []FilterArg{{Type: FilterArgString}, {Type: FilterArgString, Optional: true}},
+		Validate: func(args FilterArgs) error {
+			if args.Len() > 1 {
+				if _, err := time.LoadLocation(args.String(1)); err != nil {
+					return fmt.Errorf("has an invalid timezone: %s", err.Error())
+				}
+			}
+			return nil
+		},
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			loc := ctx.Location
+			if args.Len() > 1 {
+				var err error
+				if loc, err = time.LoadLocation(args.String(1)); err != nil {
+					return "", err
+				}
+			}
+			return filterDateParse(args.String(0), value, loc)
+		}})
+
+	RegisterFilter("regexp", Filter{Args: stringArg, Validate: validatePatternArg,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterRegexp(ctx, args.String(0), value)
+		}})
+
+	RegisterFilter("re_replace", Filter{Args: twoStringArgs, Validate: validatePatternArg,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterRegexpReplace(args.String(0), args.String(1), value)
+		}})
+
+	RegisterFilter("split", Filter{Args: []FilterArg{{Type: FilterArgString}, {Type: FilterArgInt}},
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterSplit(args.String(0), args.Int(1), value)
+		}})
+
+	RegisterFilter("replace", Filter{Args: twoStringArgs,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterReplace(args.String(0), args.String(1), value)
+		}})
+
+	RegisterFilter("trim", Filter{Args: optStringArg,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			cutset := ""
+			if args.Len() > 0 {
+				cutset = args.String(0)
+			}
+			return filterTrim(cutset, value)
+		}})
+
+	RegisterFilter("prepend", Filter{Args: stringArg,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return args.String(0) + value, nil
+		}})
+
+	RegisterFilter("append", Filter{Args: stringArg,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return value + args.String(0), nil
+		}})
+
+	RegisterFilter("tolower", Filter{Args: noArgs,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterToLower(value)
+		}})
+
+	RegisterFilter("toupper", Filter{Args: noArgs,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterToUpper(value)
+		}})
+
+	RegisterFilter("urldecode", Filter{Args: noArgs,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return url.QueryUnescape(value)
+		}})
+
+	RegisterFilter("urlencode", Filter{Args: noArgs,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return url.QueryEscape(value), nil
+		}})
+
+	RegisterFilter("htmldecode", Filter{Args: noArgs,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return html.UnescapeString(value), nil
+		}})
+
+	RegisterFilter("number", Filter{Args: optStringArg,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			if args.Len() == 0 {
+				return filterNumber(",", value)
+			}
+			return filterNumber(args.String(0), value)
+		}})
+
+	RegisterFilter("timeago", Filter{Args: noArgs,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterTimeAgo(value, ctx.now())
+		}})
+
+	RegisterFilter("reltime", Filter{Args: stringArg,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterRelTime(value, args.String(0), ctx.now())
+		}})
+
+	RegisterFilter("mapcats", Filter{Args: noArgs,
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return filterMapCategory(ctx.Categories, value)
+		}})
 }
 
 func filterQueryString(param string, value string) (string, error) {
@@ -89,8 +430,13 @@ func filterQueryString(param string, value string) (string, error) {
 	return u.Query().Get(param), nil
 }
 
-func filterDateParse(format string, value string) (string, error) {
-	t, err := time.Parse(format, value)
+// filterDateParse parses a date in the given location, dates without a location are
+// parsed as UTC unless the format includes a timezone
+func filterDateParse(format string, value string, loc *time.Location) (string, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	t, err := time.ParseInLocation(format, value, loc)
 	if err != nil {
 		return "", err
 	}
@@ -105,7 +451,7 @@ func filterSplit(sep string, pos int, value string) (string, error) {
 	return frags[pos], nil
 }
 
-func filterRegexp(pattern string, value string) (string, error) {
+func filterRegexp(ctx FilterContext, pattern string, value string) (string, error) {
 	re, err := regexp.Compile(pattern)
 	if err != nil {
 		return "", err
@@ -117,7 +463,7 @@ func filterRegexp(pattern string, value string) (string, error) {
 		return "", errors.New("No matches found for pattern")
 	}
 
-	filterLogger.WithFields(logrus.Fields{"matches": matches}).Debug("Regex matched")
+	ctx.logger().WithFields(logrus.Fields{"matches": matches}).Debug("Regex matched")
 
 	if len(matches) > 1 {
 		return matches[1], nil
@@ -126,6 +472,61 @@ func filterRegexp(pattern string, value string) (string, error) {
 	return matches[0], nil
 }
 
+func filterReplace(old string, new string, value string) (string, error) {
+	return strings.Replace(value, old, new, -1), nil
+}
+
+// filterTrim removes the characters in the cutset from both ends, or whitespace when
+// the cutset is empty
+func filterTrim(cutset string, value string) (string, error) {
+	if cutset == "" {
+		return strings.TrimSpace(value), nil
+	}
+	return strings.Trim(value, cutset), nil
+}
+
+func filterToLower(value string) (string, error) {
+	return strings.ToLower(value), nil
+}
+
+func filterToUpper(value string) (string, error) {
+	return strings.ToUpper(value), nil
+}
+
+func filterRegexpReplace(pattern string, replacement string, value string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	return re.ReplaceAllString(value, replacement), nil
+}
+
+// filterNumber removes thousands separators and whitespace from a number, e.g "1,234" becomes "1234"
+func filterNumber(sep string, value string) (string, error) {
+	out := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, value)
+
+	if sep != "" {
+		out = strings.Replace(out, sep, "", -1)
+	}
+
+	// numbers with a comma decimal separator are normalized to use a point
+	if sep == "." {
+		out = strings.Replace(out, ",", ".", 1)
+	}
+
+	if _, err := strconv.ParseFloat(out, 64); err != nil {
+		return "", fmt.Errorf("Unable to parse number %q", value)
+	}
+
+	return out, nil
+}
+
 func splitDecimalStr(s string) (int, float64, error) {
 	if parts := strings.SplitN(s, ".", 2); len(parts) == 2 {
 		i, err := strconv.Atoi(parts[0])
@@ -215,14 +616,16 @@ func filterRelTime(src string, format string, now time.Time) (string, error) {
 	return out, nil
 }
 
-func filterMapCategory(value string) (string, error) {
-	catID, err := strconv.Atoi(value)
-	if err != nil {
-		return "", fmt.Errorf("Unable to parse category id %s", value)
-	}
-	mappedCat, ok := filterCategoryMapping[catID]
+// filterMapCategory maps a site category, either an id or a label, to torznab category ids,
+// separated by commas when it maps to more than one
+func filterMapCategory(mapping torznab.CategoryMapping, value string) (string, error) {
+	mappedCats, ok := mapping.Lookup(value)
 	if !ok {
-		return "", fmt.Errorf("No category mapping found for id %d", catID)
+		return "", fmt.Errorf("No category mapping found for %q", value)
+	}
+	ids := []string{}
+	for _, cat := range mappedCats {
+		ids = append(ids, strconv.Itoa(cat.ID))
 	}
-	return strconv.Itoa(mappedCat.ID), nil
+	return strings.Join(ids, ","), nil
 }
@@ -1,6 +1,7 @@
 package indexer
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -28,7 +29,7 @@ func TestDateParseFilter(t *testing.T) {
 	for idx, example := range []struct{ strTime, format, expected string }{
 		{now.Format("Mon Jan 2 15:04:05 MST 2006"), "Mon Jan 2 15:04:05 MST 2006", now.Format(filterTimeFormat)},
 	} {
-		result, err := filterDateParse(example.format, example.strTime)
+		result, err := filterDateParse(example.format, example.strTime, time.UTC)
 		if err != nil {
 			t.Fatalf("Row %#d had an unexpected error: %s", idx+1, err.Error())
 		}
@@ -59,3 +60,139 @@ func TestTimeAgoFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestInvokeFilter(t *testing.T) {
+	for idx, example := range []struct {
+		name     string
+		args     interface{}
+		value    string
+		expected string
+	}{
+		{"replace", []interface{}{"_", " "}, "Llama_Drama_2016", "Llama Drama 2016"},
+		{"re_replace", []interface{}{`(\d+)x(\d+)`, "S${1}E${2}"}, "Llamas 01x02", "Llamas S01E02"},
+		{"re_replace", []interface{}{`\[.*?\]`, ""}, "[FREE]Llamas[HD]", "Llamas"},
+		{"trim", nil, "  Llamas \n", "Llamas"},
+		{"trim", "[]", "[Llamas]", "Llamas"},
+		{"prepend", "https://example.org", "/download/1", "https://example.org/download/1"},
+		{"append", ".torrent", "llamas", "llamas.torrent"},
+		{"tolower", nil, "LLAMAS", "llamas"},
+		{"toupper", nil, "llamas", "LLAMAS"},
+		{"urldecode", nil, "llamas%20are+great", "llamas are great"},
+		{"urlencode", nil, "llamas & alpacas", "llamas+%26+alpacas"},
+		{"htmldecode", nil, "Llamas &amp; Alpacas &#39;16", "Llamas & Alpacas '16"},
+		{"number", nil, "1,234", "1234"},
+		{"number", nil, " 1,234,567.5 ", "1234567.5"},
+		{"number", ".", "1.234,5", "1234.5"},
+	} {
+		result, err := invokeFilter(FilterContext{}, example.name, example.args, example.value)
+		if err != nil {
+			t.Fatalf("Row %d had an unexpected error: %s", idx+1, err.Error())
+		}
+		if result != example.expected {
+			t.Fatalf("Row %d was expecting %q, got %q", idx+1, example.expected, result)
+		}
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	for idx, example := range []struct {
+		name string
+		args interface{}
+	}{
+		{"replace", "_"},
+		{"replace", []interface{}{"_"}},
+		{"replace", []interface{}{"_", 1}},
+		{"re_replace", []interface{}{"(", ""}},
+		{"trim", 1},
+		{"prepend", nil},
+		{"append", []interface{}{"a"}},
+		{"split", "/"},
+		{"tolower", "a"},
+		{"urlencode", "a"},
+		{"htmldecode", true},
+		{"number", 1},
+		{"llamafy", nil},
+	} {
+		if err := validateFilter(example.name, example.args); err == nil {
+			t.Fatalf("Row %d expected an error for filter %q with args %#v", idx+1, example.name, example.args)
+		}
+	}
+
+	if _, err := invokeFilter(FilterContext{}, "number", nil, "llamas"); err == nil {
+		t.Fatal("Expected an error for a value that isn't a number")
+	}
+}
+
+func TestRegisterFilter(t *testing.T) {
+	RegisterFilter("test_repeat", Filter{
+		Args: []FilterArg{{Type: FilterArgInt}},
+		Apply: func(ctx FilterContext, args FilterArgs, value string) (string, error) {
+			return strings.Repeat(value, args.Int(0)), nil
+		},
+	})
+	defer unregisterFilter("test_repeat")
+
+	result, err := invokeFilter(FilterContext{}, "test_repeat", 3, "llama")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != "llamallamallama" {
+		t.Fatalf("Unexpected result %q", result)
+	}
+
+	if err = validateFilter("test_repeat", "3"); err == nil {
+		t.Fatal("Expected an error for a string argument")
+	}
+
+	_, err = ParseDefinition([]byte(`
+---
+  site: example
+  login:
+    method: passkey
+  search:
+    rows:
+      selector: tr
+    fields:
+      title:
+        selector: td
+        filters:
+          - name: llamafy
+`))
+	if err == nil || err.Error() != "Unknown filter llamafy" {
+		t.Fatalf("Expected an unknown filter error, got %v", err)
+	}
+}
+
+func TestDateParseTimezone(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skip("Timezone data isn't available")
+	}
+
+	for idx, example := range []struct {
+		ctx      FilterContext
+		args     interface{}
+		expected time.Time
+	}{
+		{FilterContext{}, "2006-01-02 15:04", time.Date(2017, time.January, 5, 12, 30, 0, 0, time.UTC)},
+		{FilterContext{Location: amsterdam}, "2006-01-02 15:04", time.Date(2017, time.January, 5, 11, 30, 0, 0, time.UTC)},
+		{FilterContext{}, []interface{}{"2006-01-02 15:04", "Europe/Amsterdam"}, time.Date(2017, time.January, 5, 11, 30, 0, 0, time.UTC)},
+		{FilterContext{Location: amsterdam}, []interface{}{"2006-01-02 15:04", "UTC"}, time.Date(2017, time.January, 5, 12, 30, 0, 0, time.UTC)},
+	} {
+		result, err := invokeFilter(example.ctx, "dateparse", example.args, "2017-01-05 12:30")
+		if err != nil {
+			t.Fatalf("Row %d had an unexpected error: %s", idx+1, err.Error())
+		}
+		parsed, err := time.Parse(filterTimeFormat, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !parsed.Equal(example.expected) {
+			t.Fatalf("Row %d was expecting %s, got %s", idx+1, example.expected, parsed.UTC())
+		}
+	}
+
+	if err := validateFilter("dateparse", []interface{}{"2006-01-02", "Llama/Land"}); err == nil {
+		t.Fatal("Expected an error for an unknown timezone")
+	}
+}
@@ -0,0 +1,77 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errJSONPathNotFound = errors.New("JSON path not found")
+
+func decodeJSON(body string) (interface{}, error) {
+	var data interface{}
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return nil, fmt.Errorf("Failed to decode json response: %s", err.Error())
+	}
+	return data, nil
+}
+
+// jsonPath walks a dot separated path like "data.torrents.0.name" into decoded json data,
+// numeric segments are used as indexes into arrays
+func jsonPath(data interface{}, path string) (interface{}, error) {
+	if path == "" || path == "." {
+		return data, nil
+	}
+
+	current := data
+	for _, key := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			val, ok := node[key]
+			if !ok {
+				return nil, errJSONPathNotFound
+			}
+			current = val
+		case []interface{}:
+			idx, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, fmt.Errorf("JSON path %q expected an array index, got %q", path, key)
+			}
+			if idx < 0 {
+				idx = len(node) + idx
+			}
+			if idx < 0 || idx >= len(node) {
+				return nil, errJSONPathNotFound
+			}
+			current = node[idx]
+		default:
+			return nil, errJSONPathNotFound
+		}
+	}
+
+	return current, nil
+}
+
+func jsonString(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	case json.Number:
+		return t.String(), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	}
+
+	b := &bytes.Buffer{}
+	if err := json.NewEncoder(b).Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
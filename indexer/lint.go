@@ -0,0 +1,395 @@
+package indexer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"github.com/cardigann/cardigann/torznab"
+	"gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+var (
+	stringorsliceType     = reflect.TypeOf(stringorslice{})
+	errorBlockOrSliceType = reflect.TypeOf(errorBlockOrSlice{})
+	errorBlockType        = reflect.TypeOf(errorBlock{})
+	capabilitiesBlockType = reflect.TypeOf(capabilitiesBlock{})
+	filterBlockType       = reflect.TypeOf(filterBlock{})
+	selectorBlockType     = reflect.TypeOf(selectorBlock{})
+	rowsBlockType         = reflect.TypeOf(rowsBlock{})
+	fieldsBlockType       = reflect.TypeOf(fieldsBlock{})
+	inputsBlockType       = reflect.TypeOf(inputsBlock{})
+	caseBlockType         = reflect.TypeOf(caseBlock{})
+	headersBlockType      = reflect.TypeOf(headersBlock{})
+)
+
+// selectorKeys are the keys of each block that contain css selectors
+var selectorKeys = map[reflect.Type][]string{
+	selectorBlockType:               {"selector", "remove"},
+	rowsBlockType:                   {"selector", "exclude"},
+	errorBlockType:                  {"selector"},
+	reflect.TypeOf(loginBlock{}):    {"form"},
+	reflect.TypeOf(pageTestBlock{}): {"selector"},
+}
+
+// templateKeys are the keys of each block that contain templates
+var templateKeys = map[reflect.Type][]string{
+	reflect.TypeOf(loginBlock{}):  {"cookie"},
+	reflect.TypeOf(searchBlock{}): {"path"},
+	reflect.TypeOf(pathBlock{}):   {"path"},
+	reflect.TypeOf(inputBlock{}):  {"value", "if"},
+}
+
+// LintError is a problem found in a definition by LintDefinition
+type LintError struct {
+	Line    int
+	Message string
+}
+
+func (e LintError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return e.Message
+}
+
+type linter struct {
+	errors       []LintError
+	jsonResponse bool
+}
+
+// LintDefinition strictly validates the source of a definition, reporting unknown keys,
+// filters, fields and categories as well as invalid templates and selectors
+func LintDefinition(src []byte) []LintError {
+	return lintDefinition(src, src)
+}
+
+// LintDefinitionFile lints a definition file, the definition it extends is merged in
+// before validating so that the definition is checked as it will be loaded
+func LintDefinitionFile(fileName string) []LintError {
+	src, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return []LintError{{Message: err.Error()}}
+	}
+
+	merged, err := resolveFileExtends(fileName, src)
+	if err != nil {
+		return []LintError{{Message: err.Error()}}
+	}
+
+	return lintDefinition(src, merged)
+}
+
+// lintDefinition walks the source of a definition to report problems on the lines they
+// appear on, while validating the merged definition
+func lintDefinition(src, merged []byte) []LintError {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(src, &doc); err != nil {
+		return []LintError{{Message: err.Error()}}
+	}
+
+	l := &linter{}
+
+	def, parseErr := ParseDefinition(merged)
+	if parseErr == nil {
+		l.jsonResponse = def.Search.Response.Type == responseTypeJSON
+	}
+
+	if len(doc.Content) > 0 {
+		l.walk(doc.Content[0], reflect.TypeOf(IndexerDefinition{}), "")
+	}
+
+	// the parser stops at the first problem, which is usually one the walk has already
+	// reported with a line number, so it's only reported when the walk found nothing
+	if parseErr != nil && len(l.errors) == 0 {
+		l.errors = append(l.errors, LintError{Message: parseErr.Error()})
+	}
+
+	sort.Stable(lintErrorsByLine(l.errors))
+	return l.errors
+}
+
+func (l *linter) errorf(line int, format string, args ...interface{}) {
+	l.errors = append(l.errors, LintError{line, fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) walk(node *yamlv3.Node, t reflect.Type, path string) {
+	for node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+
+	switch t {
+	case stringorsliceType:
+		return
+	case errorBlockOrSliceType:
+		if node.Kind == yamlv3.SequenceNode {
+			for idx, child := range node.Content {
+				l.walk(child, errorBlockType, fmt.Sprintf("%s[%d]", path, idx))
+			}
+		} else {
+			l.walk(node, errorBlockType, path)
+		}
+		return
+	case capabilitiesBlockType:
+		l.walkCapabilities(node, path)
+		return
+	case caseBlockType:
+		l.walkCase(node, path)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yamlv3.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, val := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				l.errorf(key.Line, "Unknown key %q in %s", key.Value, describePath(path))
+				continue
+			}
+			l.walk(val, field.Type, joinPath(path, key.Value))
+			l.checkValue(t, key.Value, val, joinPath(path, key.Value))
+		}
+		if t == filterBlockType {
+			l.checkFilter(node)
+		}
+
+	case reflect.Map:
+		if node.Kind != yamlv3.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, val := node.Content[i], node.Content[i+1]
+			switch t {
+			case fieldsBlockType:
+				if !isSearchField(key.Value) {
+					l.errorf(key.Line, "Unknown field %q in %s", key.Value, describePath(path))
+				}
+			case headersBlockType:
+				if _, err := parseTemplate(key.Value, val.Value); err != nil {
+					l.errorf(val.Line, "Invalid template for header %q: %s", key.Value, err.Error())
+				}
+			case inputsBlockType:
+				if val.Kind == yamlv3.ScalarNode {
+					if _, err := parseTemplate(key.Value, val.Value); err != nil {
+						l.errorf(val.Line, "Invalid template for input %q: %s", key.Value, err.Error())
+					}
+				}
+			}
+			l.walk(val, t.Elem(), joinPath(path, key.Value))
+		}
+
+	case reflect.Slice:
+		if node.Kind != yamlv3.SequenceNode {
+			return
+		}
+		for idx, child := range node.Content {
+			l.walk(child, t.Elem(), fmt.Sprintf("%s[%d]", path, idx))
+		}
+	}
+}
+
+// checkValue validates a scalar value within a block that is a selector or a template
+func (l *linter) checkValue(t reflect.Type, key string, val *yamlv3.Node, path string) {
+	if val.Kind != yamlv3.ScalarNode || val.Value == "" {
+		return
+	}
+
+	for _, k := range selectorKeys[t] {
+		if k != key {
+			continue
+		}
+		if l.jsonResponse && (t == selectorBlockType || t == rowsBlockType) && key == "selector" &&
+			(strings.HasPrefix(path, "search.rows") || strings.HasPrefix(path, "search.fields")) {
+			continue
+		}
+		if _, err := cascadia.Compile(val.Value); err != nil {
+			l.errorf(val.Line, "Invalid selector %q in %s: %s", val.Value, path, err.Error())
+		}
+	}
+
+	for _, k := range templateKeys[t] {
+		if k != key {
+			continue
+		}
+		if _, err := parseTemplate(key, val.Value); err != nil {
+			l.errorf(val.Line, "Invalid template in %s: %s", path, err.Error())
+		}
+	}
+}
+
+func (l *linter) checkFilter(node *yamlv3.Node) {
+	var name string
+	var args interface{}
+	var nameLine = node.Line
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "name":
+			name, nameLine = val.Value, val.Line
+		case "args":
+			args = nodeValue(val)
+		}
+	}
+
+	if err := validateFilter(name, args); err != nil {
+		l.errorf(nameLine, "%s", err.Error())
+	}
+}
+
+// walkCase checks that the keys of a case block are valid selectors
+func (l *linter) walkCase(node *yamlv3.Node, path string) {
+	if node.Kind != yamlv3.MappingNode {
+		l.errorf(node.Line, "Expected a mapping of selectors to values in %s", describePath(path))
+		return
+	}
+
+	if l.jsonResponse {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if key.Value == caseDefaultKey {
+			continue
+		}
+		if _, err := cascadia.Compile(key.Value); err != nil {
+			l.errorf(key.Line, "Invalid selector %q in %s: %s", key.Value, path, err.Error())
+		}
+	}
+}
+
+func (l *linter) walkCapabilities(node *yamlv3.Node, path string) {
+	if node.Kind != yamlv3.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i], node.Content[i+1]
+		switch key.Value {
+		case "categories":
+			if val.Kind != yamlv3.MappingNode {
+				continue
+			}
+			for j := 0; j+1 < len(val.Content); j += 2 {
+				cats := []*yamlv3.Node{val.Content[j+1]}
+				if val.Content[j+1].Kind == yamlv3.SequenceNode {
+					cats = val.Content[j+1].Content
+				}
+				for _, cat := range cats {
+					if !isCategoryName(cat.Value) {
+						l.errorf(cat.Line, "Unknown category %q", cat.Value)
+					}
+				}
+			}
+		case "modes":
+		default:
+			l.errorf(key.Line, "Unknown key %q in %s", key.Value, describePath(path))
+		}
+	}
+}
+
+// nodeValue converts a node to the same types the definition parser produces
+func nodeValue(node *yamlv3.Node) interface{} {
+	for node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+
+	switch node.Kind {
+	case yamlv3.SequenceNode:
+		vals := []interface{}{}
+		for _, child := range node.Content {
+			vals = append(vals, nodeValue(child))
+		}
+		return vals
+	case yamlv3.MappingNode:
+		vals := map[interface{}]interface{}{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			vals[nodeValue(node.Content[i])] = nodeValue(node.Content[i+1])
+		}
+		return vals
+	}
+
+	var v interface{}
+	switch node.ShortTag() {
+	case "!!int", "!!float", "!!bool", "!!null":
+		if err := yaml.Unmarshal([]byte(node.Value), &v); err == nil {
+			return v
+		}
+	}
+
+	return node.Value
+}
+
+// yamlFields returns the struct fields of a type keyed by their yaml name
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+
+	return fields
+}
+
+func isSearchField(name string) bool {
+	for _, f := range searchFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isCategoryName(name string) bool {
+	for _, cat := range torznab.AllCategories {
+		if cat.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "definition"
+	}
+	return path
+}
+
+type lintErrorsByLine []LintError
+
+func (slice lintErrorsByLine) Len() int {
+	return len(slice)
+}
+
+func (slice lintErrorsByLine) Less(i, j int) bool {
+	return slice[i].Line < slice[j].Line
+}
+
+func (slice lintErrorsByLine) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
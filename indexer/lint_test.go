@@ -0,0 +1,132 @@
+package indexer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const exampleInvalidDefinition = `
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      2:  Audio
+      3:  Llamas
+
+  login:
+    path: /login.php
+    form: "form["
+    inputs:
+      username: "{{ .Config.username"
+
+  search:
+    path: torrents.php
+    rows:
+      selectr: table.results tbody tr
+    fields:
+      title:
+        selector: td:nth-child(2) a
+        filters:
+          - name: llamafy
+          - name: split
+            args: "/"
+      titel:
+        selector: td:nth-child(2) a
+`
+
+func TestLintDefinition(t *testing.T) {
+	errs := LintDefinition([]byte(exampleInvalidDefinition))
+
+	expected := []LintError{
+		{10, `Unknown category "Llamas"`},
+		{14, `Invalid selector "form[" in login.form`},
+		{16, `Invalid template for input "username"`},
+		{21, `Unknown key "selectr" in search.rows`},
+		{26, `Unknown filter llamafy`},
+		{27, `Filter "split" requires a string and an int argument`},
+		{29, `Unknown field "titel" in search.fields`},
+	}
+
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expected), len(errs), errs)
+	}
+
+	for idx, e := range expected {
+		if errs[idx].Line != e.Line || !strings.HasPrefix(errs[idx].Message, e.Message) {
+			t.Fatalf("Error %d was expected to be %q, got %q", idx+1, e.Error(), errs[idx].Error())
+		}
+	}
+}
+
+func TestLintShippedDefinitions(t *testing.T) {
+	files, err := filepath.Glob("../definitions/*.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		if errs := LintDefinitionFile(file); len(errs) > 0 {
+			t.Fatalf("Definition %s failed linting: %v", file, errs)
+		}
+	}
+}
+
+func TestLintDefinitionFileResolvesExtends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "definitions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"_base.yml": `
+---
+  site: base
+  caps:
+    categories:
+      1: TV
+  search:
+    paths:
+      - path: tv.php
+        categories: [1]
+`,
+		// only valid with the categories of the extended definition
+		"valid.yml": `
+---
+  extends: _base
+  site: valid
+  search:
+    paths:
+      - path: shows.php
+        categories: [1]
+`,
+		// only invalid once merged, as both a path and paths are set
+		"invalid.yml": `
+---
+  extends: _base
+  site: invalid
+  search:
+    path: search.php
+`,
+	}
+
+	for name, src := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if errs := LintDefinitionFile(filepath.Join(dir, "valid.yml")); len(errs) > 0 {
+		t.Fatalf("Expected no errors for the merged definition, got %v", errs)
+	}
+
+	if errs := LintDefinitionFile(filepath.Join(dir, "invalid.yml")); len(errs) != 1 {
+		t.Fatalf("Expected 1 error for the merged definition, got %v", errs)
+	}
+}
@@ -58,6 +58,10 @@ func ListDefinitions() ([]string, error) {
 	results := []string{}
 
 	for k := range keys {
+		// definitions prefixed with an underscore are only used as a base for others
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
 		results = append(results, k)
 	}
 
@@ -70,14 +74,26 @@ func LoadDefinition(key string) (*IndexerDefinition, error) {
 		return nil, err
 	}
 
-	fileName, ok := defs[key]
-	if !ok {
-		return nil, ErrUnknownIndexer
+	data, err := loadDefinitionSource(defs, key)
+	if err != nil {
+		return nil, err
 	}
 
-	data, err := ioutil.ReadFile(fileName)
+	data, err = resolveExtends(data, func(base string) ([]byte, error) {
+		return loadDefinitionSource(defs, base)
+	})
 	if err != nil {
 		return nil, err
 	}
+
 	return ParseDefinition(data)
 }
+
+func loadDefinitionSource(defs map[string]string, key string) ([]byte, error) {
+	fileName, ok := defs[key]
+	if !ok {
+		return nil, ErrUnknownIndexer
+	}
+
+	return ioutil.ReadFile(fileName)
+}
@@ -0,0 +1,205 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/headzoo/surf"
+	"github.com/headzoo/surf/agent"
+)
+
+// activeMirrors remembers which mirror of each site last worked, shared by all runners
+var activeMirrors = struct {
+	sync.RWMutex
+	sites map[string]string
+}{sites: map[string]string{}}
+
+// MirrorStatus is the result of checking whether a mirror of a site can be reached
+type MirrorStatus struct {
+	URL   string `json:"url"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// mirrors returns the urls of the site in order of preference, starting with the configured url
+func (r *Runner) mirrors() []string {
+	results := []string{}
+	seen := map[string]bool{}
+
+	links := []string(r.Definition.Links)
+	if configURL, ok, _ := r.Config.Get(r.Definition.Site, "url"); ok && configURL != "" {
+		links = append([]string{configURL}, links...)
+	}
+
+	for _, link := range links {
+		key := strings.TrimSuffix(link, "/")
+		if !seen[key] {
+			results = append(results, link)
+			seen[key] = true
+		}
+	}
+
+	return results
+}
+
+func (r *Runner) activeMirror() (string, bool) {
+	activeMirrors.RLock()
+	active, ok := activeMirrors.sites[r.Definition.Site]
+	activeMirrors.RUnlock()
+
+	if !ok {
+		return "", false
+	}
+
+	// the mirror might have been removed from the config since it was used
+	for _, mirror := range r.mirrors() {
+		if mirror == active {
+			return active, true
+		}
+	}
+
+	return "", false
+}
+
+func (r *Runner) setActiveMirror(mirror string) {
+	activeMirrors.Lock()
+	activeMirrors.sites[r.Definition.Site] = mirror
+	activeMirrors.Unlock()
+}
+
+func (r *Runner) checkResponse(err error) error {
+	if err != nil {
+		return err
+	}
+	if code := r.Browser.StatusCode(); code >= 500 {
+		return fmt.Errorf("Site responded with status %d", code)
+	}
+	return nil
+}
+
+// request calls fn with the url, retrying against each of the other mirrors of the
+// site in turn if the url can't be reached. Urls that belong to a mirror are sent
+// to the last working mirror first.
+func (r *Runner) request(u string, fn func(u string) error) error {
+	parsed, err := url.Parse(u)
+	if err != nil || !r.isMirrorHost(parsed.Host) {
+		return r.checkResponse(r.throttled(fn)(u))
+	}
+
+	candidates := append([]string{u}, r.mirrors()...)
+	if active, ok := r.activeMirror(); ok {
+		candidates = append([]string{active}, candidates...)
+	}
+
+	tried := map[string]bool{}
+	fn = r.throttled(fn)
+
+	for _, mirror := range candidates {
+		m, mErr := url.Parse(mirror)
+		if mErr != nil || tried[m.Host] {
+			continue
+		}
+		tried[m.Host] = true
+
+		alt := *parsed
+		alt.Scheme = m.Scheme
+		alt.Host = m.Host
+
+		if err != nil {
+			r.Logger.
+				WithFields(logrus.Fields{"url": u, "mirror": m.Host}).
+				WithError(err).
+				Warn("Request failed, trying next mirror")
+		}
+
+		if err = r.checkResponse(fn(alt.String())); err == nil {
+			r.setActiveMirror(r.mirrorForHost(m.Host))
+			return nil
+		} else if err == context.Canceled || err == context.DeadlineExceeded {
+			return err
+		}
+	}
+
+	return err
+}
+
+// throttled wraps fn so that it waits for the site's rate limit before each request
+func (r *Runner) throttled(fn func(u string) error) func(u string) error {
+	return func(u string) error {
+		if err := r.waitForRequest(); err != nil {
+			return err
+		}
+		return fn(u)
+	}
+}
+
+func (r *Runner) mirrorForHost(host string) string {
+	for _, mirror := range r.mirrors() {
+		if m, err := url.Parse(mirror); err == nil && m.Host == host {
+			return mirror
+		}
+	}
+	return ""
+}
+
+func (r *Runner) isMirrorHost(host string) bool {
+	return r.mirrorForHost(host) != ""
+}
+
+// CheckMirrors requests the front page of each mirror of the site and reports
+// whether it could be reached
+func (r *Runner) CheckMirrors() []MirrorStatus {
+	results := []MirrorStatus{}
+
+	for _, mirror := range r.mirrors() {
+		bow := surf.NewBrowser()
+		bow.SetUserAgent(agent.Chrome())
+
+		status := MirrorStatus{URL: mirror, OK: true}
+
+		if err := r.waitForRequest(); err != nil {
+			status.OK, status.Error = false, err.Error()
+		} else if err := bow.Open(mirror); err != nil {
+			status.OK, status.Error = false, err.Error()
+		} else if bow.StatusCode() >= 500 {
+			status.OK, status.Error = false, fmt.Sprintf("Site responded with status %d", bow.StatusCode())
+		}
+
+		r.Logger.
+			WithFields(logrus.Fields{"mirror": mirror, "ok": status.OK, "error": status.Error}).
+			Info("Checked mirror")
+
+		results = append(results, status)
+	}
+
+	r.mirrorStatuses = results
+	return results
+}
+
+// MirrorStatuses returns the result of the last check of the site's mirrors, as made by
+// Test, so that callers can see which mirrors are down even when the test passed
+func (r *Runner) MirrorStatuses() []MirrorStatus {
+	return r.mirrorStatuses
+}
+
+func checkMirrorResults(statuses []MirrorStatus) error {
+	failed := []string{}
+
+	for _, status := range statuses {
+		if status.OK {
+			return nil
+		}
+		failed = append(failed, fmt.Sprintf("%s (%s)", status.URL, status.Error))
+	}
+
+	if len(failed) == 0 {
+		return errors.New("No links or url configured for site")
+	}
+
+	return fmt.Errorf("No mirrors could be reached: %s", strings.Join(failed, ", "))
+}
@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/cardigann/cardigann/torznab"
@@ -15,11 +19,15 @@ import (
 )
 
 type IndexerDefinition struct {
+	Extends      string            `yaml:"extends,omitempty"`
 	Site         string            `yaml:"site"`
 	Name         string            `yaml:"name"`
 	Description  string            `yaml:"description"`
 	Language     string            `yaml:"language"`
 	Links        stringorslice     `yaml:"links"`
+	RequestDelay float64           `yaml:"requestdelay"`
+	Timezone     string            `yaml:"timezone"`
+	Settings     []settingsField   `yaml:"settings"`
 	Capabilities capabilitiesBlock `yaml:"caps"`
 	Login        loginBlock        `yaml:"login"`
 	Search       searchBlock       `yaml:"search"`
@@ -31,28 +39,220 @@ func ParseDefinitionFile(f *os.File) (*IndexerDefinition, error) {
 		return nil, err
 	}
 
+	b, err = resolveFileExtends(f.Name(), b)
+	if err != nil {
+		return nil, err
+	}
+
 	return ParseDefinition(b)
 }
 
+// resolveFileExtends resolves the definition a file extends, looking alongside the file
+// before the definitions folders, which are only searched when the file has extends
+func resolveFileExtends(fileName string, src []byte) ([]byte, error) {
+	return resolveExtends(src, func(base string) ([]byte, error) {
+		sibling := filepath.Join(filepath.Dir(fileName), base+".yml")
+		if _, err := os.Stat(sibling); err == nil {
+			return ioutil.ReadFile(sibling)
+		}
+
+		defs, err := findDefinitions()
+		if err != nil {
+			return nil, err
+		}
+
+		return loadDefinitionSource(defs, base)
+	})
+}
+
 func ParseDefinition(src []byte) (*IndexerDefinition, error) {
 	def := IndexerDefinition{
 		Language:     "en-us",
 		Capabilities: capabilitiesBlock{},
 		Login: loginBlock{
+			Method:       loginMethodForm,
 			FormSelector: "form",
 			Inputs:       inputsBlock{},
 		},
-		Search: searchBlock{},
+		Search: searchBlock{
+			Method:   searchMethodGet,
+			Response: responseBlock{Type: responseTypeHTML},
+		},
 	}
 
 	if err := yaml.Unmarshal(src, &def); err != nil {
 		return nil, err
 	}
 
+	if def.Settings == nil {
+		def.Settings = defaultSettings(def.Login)
+	}
+
+	for _, setting := range def.Settings {
+		if err := setting.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	switch def.Login.Method {
+	case loginMethodForm, loginMethodPost, loginMethodCookie, loginMethodBasic, loginMethodPasskey:
+	default:
+		return nil, fmt.Errorf("Unknown login method %q", def.Login.Method)
+	}
+
+	if def.Login.Method == loginMethodBasic {
+		for _, name := range []string{"username", "password"} {
+			if _, ok := def.Login.Inputs[name]; !ok {
+				return nil, fmt.Errorf("Basic login requires a %q input", name)
+			}
+		}
+	}
+
+	switch def.Search.Response.Type {
+	case responseTypeHTML, responseTypeJSON:
+	default:
+		return nil, fmt.Errorf("Unknown search response type %q", def.Search.Response.Type)
+	}
+
+	if def.Search.Rows.After < 0 {
+		return nil, fmt.Errorf("Invalid rows after %d, must not be negative", def.Search.Rows.After)
+	}
+
+	if def.Search.Path != "" && len(def.Search.Paths) > 0 {
+		return nil, errors.New("Only one of search path or paths can be set")
+	}
+
+	for _, p := range def.Search.Paths {
+		for _, cat := range p.Categories {
+			if _, ok := def.Capabilities.Categories[cat]; !ok && !isCategoryName(cat) {
+				return nil, fmt.Errorf("Unknown category %q for search path %q", cat, p.Path)
+			}
+		}
+	}
+
+	switch def.Search.Method {
+	case searchMethodGet, searchMethodPost, searchMethodJSONPost:
+	default:
+		return nil, fmt.Errorf("Unknown search method %q", def.Search.Method)
+	}
+
+	if def.Timezone != "" {
+		if _, err := time.LoadLocation(def.Timezone); err != nil {
+			return nil, fmt.Errorf("Unknown timezone %q", def.Timezone)
+		}
+	}
+
+	if def.RequestDelay < 0 {
+		return nil, fmt.Errorf("Invalid requestdelay %v, must not be negative", def.RequestDelay)
+	}
+
+	if err := validateFilters(reflect.ValueOf(def)); err != nil {
+		return nil, err
+	}
+
 	return &def, nil
 }
 
-type inputsBlock map[string]string
+const (
+	settingTypeText     = "text"
+	settingTypePassword = "password"
+	settingTypeCheckbox = "checkbox"
+	settingTypeSelect   = "select"
+)
+
+// defaultSettingsFields are the settings a definition gets when it doesn't declare any
+// but its login templates refer to them
+var defaultSettingsFields = []settingsField{
+	{Name: "username", Label: "Username", Type: settingTypeText, Required: true},
+	{Name: "password", Label: "Password", Type: settingTypePassword, Required: true},
+	{Name: "cookie", Label: "Cookie", Type: settingTypePassword, Required: true},
+}
+
+var configRefPattern = regexp.MustCompile(`\.Config\.(\w+)`)
+
+// defaultSettings returns the settings used by definitions that don't declare any, which
+// are the default settings that are referenced by the login templates
+func defaultSettings(login loginBlock) []settingsField {
+	templates := []string{login.Cookie}
+	if login.Method == loginMethodCookie && login.Cookie == "" {
+		templates = append(templates, defaultLoginCookie)
+	}
+	for _, input := range login.Inputs {
+		templates = append(templates, input.Value, input.If)
+	}
+	for _, header := range login.Headers {
+		templates = append(templates, header)
+	}
+
+	refs := map[string]bool{}
+	for _, tpl := range templates {
+		for _, match := range configRefPattern.FindAllStringSubmatch(tpl, -1) {
+			refs[match[1]] = true
+		}
+	}
+
+	settings := []settingsField{}
+	for _, setting := range defaultSettingsFields {
+		if refs[setting.Name] {
+			settings = append(settings, setting)
+		}
+	}
+
+	return settings
+}
+
+// settingsField is a config key that a definition uses, shown in the web interface
+type settingsField struct {
+	Name     string            `yaml:"name" json:"name"`
+	Label    string            `yaml:"label" json:"label"`
+	Type     string            `yaml:"type" json:"type"`
+	Default  string            `yaml:"default,omitempty" json:"default,omitempty"`
+	Required bool              `yaml:"required" json:"required"`
+	Options  map[string]string `yaml:"options,omitempty" json:"options,omitempty"`
+}
+
+func (s settingsField) validate() error {
+	if s.Name == "" {
+		return errors.New("Settings must have a name")
+	}
+
+	switch s.Type {
+	case settingTypeText, settingTypePassword, settingTypeCheckbox:
+	case settingTypeSelect:
+		if len(s.Options) == 0 {
+			return fmt.Errorf("Select setting %q must have options", s.Name)
+		}
+	default:
+		return fmt.Errorf("Unknown type %q for setting %q", s.Type, s.Name)
+	}
+
+	return nil
+}
+
+type inputsBlock map[string]inputBlock
+
+// inputBlock is a form input, either a template string or a block with conditions
+type inputBlock struct {
+	Value       string `yaml:"value"`
+	If          string `yaml:"if,omitempty"`
+	OmitEmpty   bool   `yaml:"omitempty,omitempty"`
+	PerCategory bool   `yaml:"percategory,omitempty"`
+}
+
+// UnmarshalYAML implements the Unmarshaller interface.
+func (i *inputBlock) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		*i = inputBlock{Value: value}
+		return nil
+	}
+
+	type plain inputBlock
+	return unmarshal((*plain)(i))
+}
+
+// headersBlock are request headers, the values of which are templates
+type headersBlock map[string]string
 
 type errorBlockOrSlice []errorBlock
 
@@ -93,26 +293,65 @@ func (e *errorBlock) matchPage(browser browser.Browsable) bool {
 	return false
 }
 
-func (e *errorBlock) errorText(from *goquery.Selection) (string, error) {
+func (e *errorBlock) errorText(ctx FilterContext, from *goquery.Selection) (string, error) {
 	if !e.Message.IsEmpty() {
-		return e.Message.Text(from)
+		return e.Message.Text(ctx, from)
 	} else if e.Selector != "" {
 		return from.Find(e.Selector).Text(), nil
 	}
 	return "", errors.New("Error declaration must have either Message block or Selection")
 }
 
+const (
+	loginMethodForm    = "form"
+	loginMethodPost    = "post"
+	loginMethodCookie  = "cookie"
+	loginMethodBasic   = "basic"
+	loginMethodPasskey = "passkey"
+
+	defaultLoginCookie = "{{ .Config.cookie }}"
+)
+
 type loginBlock struct {
 	Path         string            `yaml:"path"`
+	Method       string            `yaml:"method"`
 	FormSelector string            `yaml:"form"`
 	Inputs       inputsBlock       `yaml:"inputs,omitempty"`
+	Cookie       string            `yaml:"cookie,omitempty"`
 	Error        errorBlockOrSlice `yaml:"error,omitempty"`
+	Test         pageTestBlock     `yaml:"test,omitempty"`
+	Headers      headersBlock      `yaml:"headers,omitempty"`
+}
+
+// pageTestBlock identifies a page by the path the request ended up on or by a selector
+// matching, such as the login test page or the page shown to a user that is logged out
+type pageTestBlock struct {
+	Path     string `yaml:"path"`
+	Selector string `yaml:"selector"`
+}
+
+func (t *pageTestBlock) IsEmpty() bool {
+	return t.Path == "" && t.Selector == ""
+}
+
+func (t *pageTestBlock) matchPage(browser browser.Browsable) bool {
+	if u := browser.Url(); t.Path != "" && u != nil {
+		if strings.TrimPrefix(u.Path, "/") == strings.TrimPrefix(t.Path, "/") {
+			return true
+		}
+	}
+
+	if t.Selector != "" {
+		return browser.Find(t.Selector).Length() > 0
+	}
+
+	return false
 }
 
-func (l *loginBlock) hasError(browser browser.Browsable) error {
+func (l *loginBlock) hasError(ctx FilterContext, browser browser.Browsable) error {
 	for _, e := range l.Error {
 		if e.matchPage(browser) {
-			msg, err := e.errorText(browser.Dom())
+			msg, err := e.errorText(ctx, browser.Dom())
 			if err != nil {
 				return err
 			}
@@ -125,11 +364,118 @@ func (l *loginBlock) hasError(browser browser.Browsable) error {
 
 type fieldsBlock map[string]selectorBlock
 
+// searchFields are the fields that can be extracted from a search result row
+var searchFields = []string{
+	"download",
+	"details",
+	"comments",
+	"title",
+	"description",
+	"category",
+	"size",
+	"leechers",
+	"seeders",
+	"date",
+	"infohash",
+	"magnet",
+	"grabs",
+	"files",
+	"imdb",
+	"tvdbid",
+	"tvrageid",
+	"banner",
+	"poster",
+	"downloadvolumefactor",
+	"uploadvolumefactor",
+}
+
+const (
+	responseTypeHTML = "html"
+	responseTypeJSON = "json"
+)
+
+const (
+	searchMethodGet      = "get"
+	searchMethodPost     = "post"
+	searchMethodJSONPost = "json-post"
+)
+
+type responseBlock struct {
+	Type string `yaml:"type"`
+}
+
+const defaultMaxPages = 10
+
+type pagingBlock struct {
+	Next      selectorBlock `yaml:"next,omitempty"`
+	Inputs    inputsBlock   `yaml:"inputs,omitempty"`
+	PageSize  int           `yaml:"pagesize"`
+	PageStart int           `yaml:"pagestart"`
+	MaxPages  int           `yaml:"maxpages"`
+}
+
+func (p *pagingBlock) IsEmpty() bool {
+	return p.Next.IsEmpty() && len(p.Inputs) == 0
+}
+
+func (p *pagingBlock) maxPages() int {
+	if p.MaxPages > 0 {
+		return p.MaxPages
+	}
+	return defaultMaxPages
+}
+
 type searchBlock struct {
-	Path   string        `yaml:"path"`
-	Inputs inputsBlock   `yaml:"inputs,omitempty"`
-	Rows   selectorBlock `yaml:"rows"`
-	Fields fieldsBlock   `yaml:"fields"`
+	Path            string        `yaml:"path"`
+	Paths           []pathBlock   `yaml:"paths,omitempty"`
+	Method          string        `yaml:"method,omitempty"`
+	Headers         headersBlock  `yaml:"headers,omitempty"`
+	Inputs          inputsBlock   `yaml:"inputs,omitempty"`
+	KeywordsFilters []filterBlock `yaml:"keywordsfilters,omitempty"`
+	Response        responseBlock `yaml:"response,omitempty"`
+	Paging          pagingBlock   `yaml:"paging,omitempty"`
+	LoggedOut       pageTestBlock `yaml:"loggedout,omitempty"`
+	Rows            rowsBlock     `yaml:"rows"`
+	Fields          fieldsBlock   `yaml:"fields"`
+}
+
+// rowsBlock selects the search results on a page, a result can span several rows and
+// rows can be excluded or used as date headers for the results that follow them
+type rowsBlock struct {
+	Selector    string        `yaml:"selector"`
+	After       int           `yaml:"after,omitempty"`
+	Exclude     string        `yaml:"exclude,omitempty"`
+	DateHeaders selectorBlock `yaml:"dateheaders,omitempty"`
+}
+
+// pathBlock is a search path that serves only some categories, given as either site
+// categories or torznab category names
+type pathBlock struct {
+	Path       string        `yaml:"path"`
+	Categories stringorslice `yaml:"categories,omitempty"`
+}
+
+// matches returns whether the path serves any of the torznab or site categories
+func (p *pathBlock) matches(cats []int, localCats []string) bool {
+	for _, pathCat := range p.Categories {
+		for _, localCat := range localCats {
+			if pathCat == localCat {
+				return true
+			}
+		}
+		for _, cat := range torznab.AllCategories {
+			if cat.Name != pathCat {
+				continue
+			}
+			for _, id := range cats {
+				if id == cat.ID {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
 }
 
 type capabilitiesBlock torznab.Capabilities
@@ -137,24 +483,27 @@ type capabilitiesBlock torznab.Capabilities
 // UnmarshalYAML implements the Unmarshaller interface.
 func (c *capabilitiesBlock) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var intermediate struct {
-		Categories map[int]string           `yaml:"categories"`
+		Categories map[string]stringorslice `yaml:"categories"`
 		Modes      map[string]stringorslice `yaml:"modes"`
 	}
 
 	if err := unmarshal(&intermediate); err == nil {
 		c.Categories = torznab.CategoryMapping{}
 
-		for id, catName := range intermediate.Categories {
-			matchedCat := false
-			for _, cat := range torznab.AllCategories {
-				if cat.Name == catName {
-					c.Categories[id] = cat
-					matchedCat = true
-					break
+		// a site category can appear under several torznab categories
+		for id, catNames := range intermediate.Categories {
+			for _, catName := range catNames {
+				matchedCat := false
+				for _, cat := range torznab.AllCategories {
+					if cat.Name == catName {
+						c.Categories[id] = append(c.Categories[id], cat)
+						matchedCat = true
+						break
+					}
+				}
+				if !matchedCat {
+					return fmt.Errorf("Unknown category %q", catName)
 				}
-			}
-			if !matchedCat {
-				return fmt.Errorf("Unknown category %q", catName)
 			}
 		}
 
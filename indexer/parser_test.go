@@ -66,12 +66,39 @@ func TestIndexerParser(t *testing.T) {
 		t.Fatalf("Supported parameters for tv-search were parsed incorrectly as %v", supported)
 	}
 
-	cat, ok := torznab.Capabilities(def.Capabilities).Categories[6]
+	cats, ok := torznab.Capabilities(def.Capabilities).Categories["6"]
 	if !ok {
 		t.Fatalf("Failed to find a mapping for category 6")
 	}
 
-	if cat != torznab.CategoryAudio {
+	if len(cats) != 1 || cats[0] != torznab.CategoryAudio {
 		t.Fatalf("Failed to find a mapping for category 6 to torznab.CategoryAudio")
 	}
 }
+
+func TestDefaultSettings(t *testing.T) {
+	for idx, row := range []struct {
+		login    string
+		expected []string
+	}{
+		{"method: form\n    inputs:\n      user: \"{{ .Config.username }}\"\n      pass: \"{{ .Config.password }}\"", []string{"username", "password"}},
+		{"method: post\n    inputs:\n      key: \"{{ .Config.apikey }}\"", []string{}},
+		{"method: form", []string{}},
+		{"method: cookie", []string{"cookie"}},
+		{"method: passkey", []string{}},
+	} {
+		def, err := ParseDefinition([]byte("site: example\nlogin:\n    " + row.login + "\n"))
+		if err != nil {
+			t.Fatalf("Row %d: %v", idx+1, err)
+		}
+
+		names := []string{}
+		for _, setting := range def.Settings {
+			names = append(names, setting.Name)
+		}
+
+		if !reflect.DeepEqual(names, row.expected) {
+			t.Fatalf("Row %d: Expected settings %v, got %v", idx+1, row.expected, names)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// siteLimiters spaces out the requests made to each site, shared by all runners
+var siteLimiters = struct {
+	sync.Mutex
+	sites map[string]*rateLimiter
+}{sites: map[string]*rateLimiter{}}
+
+type rateLimiter struct {
+	sync.Mutex
+	next time.Time
+}
+
+// acquire waits for the next free slot and claims it for a request. The slot is only
+// claimed once the wait is over, so a cancelled wait doesn't delay later requests.
+func (l *rateLimiter) acquire(ctx context.Context, interval time.Duration, logger logrus.FieldLogger) error {
+	for {
+		l.Lock()
+		now := time.Now()
+		wait := l.next.Sub(now)
+		if wait <= 0 {
+			l.next = now.Add(interval)
+			l.Unlock()
+			return nil
+		}
+		l.Unlock()
+
+		logger.
+			WithFields(logrus.Fields{"wait": wait}).
+			Debug("Waiting before next request to site")
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func limiterForSite(site string) *rateLimiter {
+	siteLimiters.Lock()
+	defer siteLimiters.Unlock()
+
+	l, ok := siteLimiters.sites[site]
+	if !ok {
+		l = &rateLimiter{}
+		siteLimiters.sites[site] = l
+	}
+
+	return l
+}
+
+// requestInterval returns the minimum time between requests to the site, taken from
+// the definition's requestdelay or the ratelimit config (requests per minute),
+// whichever is slower
+func (r *Runner) requestInterval() (time.Duration, error) {
+	interval := time.Duration(r.Definition.RequestDelay * float64(time.Second))
+
+	if val, ok, _ := r.Config.Get(r.Definition.Site, "ratelimit"); ok && val != "" {
+		rate, err := strconv.ParseFloat(val, 64)
+		if err != nil || rate < 0 {
+			return 0, fmt.Errorf("Invalid ratelimit %q for %s", val, r.Definition.Site)
+		}
+
+		if rate > 0 {
+			if limit := time.Duration(float64(time.Minute) / rate); limit > interval {
+				interval = limit
+			}
+		}
+	}
+
+	return interval, nil
+}
+
+func (r *Runner) context() context.Context {
+	if r.Context != nil {
+		return r.Context
+	}
+	return context.Background()
+}
+
+// waitForRequest blocks until the site can be sent another request, or the runner's
+// context is cancelled
+func (r *Runner) waitForRequest() error {
+	ctx := r.context()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	interval, err := r.requestInterval()
+	if err != nil || interval <= 0 {
+		return err
+	}
+
+	return limiterForSite(r.Definition.Site).acquire(ctx, interval, r.Logger)
+}
@@ -0,0 +1,84 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cardigann/cardigann/config"
+)
+
+func TestRunnerWaitForRequest(t *testing.T) {
+	def, err := ParseDefinition([]byte(`
+---
+  site: ratelimited
+  requestdelay: 0.05
+  login:
+    method: passkey
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{}
+
+	r1, r2 := NewRunner(def, conf), NewRunner(def, conf)
+
+	start := time.Now()
+
+	if err = r1.waitForRequest(); err != nil {
+		t.Fatal(err)
+	}
+	if err = r2.waitForRequest(); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("Expected runners for the same site to share a delay, took %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r1.Context = ctx
+	cancel()
+
+	if err = r1.waitForRequest(); err != context.Canceled {
+		t.Fatalf("Expected a cancelled request, got %v", err)
+	}
+
+	// a request cancelled while waiting mustn't hold up the requests after it
+	time.Sleep(50 * time.Millisecond)
+	if err = r2.waitForRequest(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r1.Context = ctx
+
+	if err = r1.waitForRequest(); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the wait to time out, got %v", err)
+	}
+
+	r1.Context = context.Background()
+	time.Sleep(45 * time.Millisecond)
+	start = time.Now()
+
+	if err = r1.waitForRequest(); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Fatalf("Expected the cancelled wait not to claim a slot, waited %s", elapsed)
+	}
+
+	(*conf)["ratelimited"] = map[string]string{"ratelimit": "60"}
+
+	interval, err := r2.requestInterval()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if interval != time.Second {
+		t.Fatalf("Expected the configured rate limit to apply, got %s", interval)
+	}
+}
@@ -2,17 +2,23 @@ package indexer
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
-	"text/template"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/Sirupsen/logrus"
 	"github.com/cardigann/cardigann/config"
 	"github.com/cardigann/cardigann/torznab"
@@ -24,14 +30,20 @@ import (
 
 var (
 	_ torznab.Indexer = &Runner{}
+
+	errLoggedOut = errors.New("Logged out of site")
 )
 
 type Runner struct {
-	Definition *IndexerDefinition
-	Browser    browser.Browsable
-	Config     config.Config
-	Logger     logrus.FieldLogger
-	caps       torznab.Capabilities
+	Definition     *IndexerDefinition
+	Browser        browser.Browsable
+	Config         config.Config
+	CookieStore    CookieStore
+	Logger         logrus.FieldLogger
+	Context        context.Context
+	caps           torznab.Capabilities
+	cookiesLoaded  bool
+	mirrorStatuses []MirrorStatus
 }
 
 func NewRunner(def *IndexerDefinition, conf config.Config) *Runner {
@@ -39,6 +51,7 @@ func NewRunner(def *IndexerDefinition, conf config.Config) *Runner {
 	bow.SetUserAgent(agent.Chrome())
 	bow.SetAttribute(browser.SendReferer, false)
 	bow.SetAttribute(browser.MetaRefreshHandling, false)
+	bow.SetCookieJar(newCookieRecorder(bow.CookieJar()))
 
 	logger := logrus.New()
 	logger.Level = logrus.DebugLevel
@@ -52,7 +65,7 @@ func NewRunner(def *IndexerDefinition, conf config.Config) *Runner {
 }
 
 func (r *Runner) applyTemplate(name, tpl string, ctx interface{}) (string, error) {
-	tmpl, err := template.New(name).Parse(tpl)
+	tmpl, err := parseTemplate(name, tpl)
 	if err != nil {
 		return "", err
 	}
@@ -69,11 +82,20 @@ func (r *Runner) currentURL() (*url.URL, error) {
 		return u, nil
 	}
 
-	if configURL, ok, _ := r.Config.Get(r.Definition.Site, "url"); ok {
-		return url.Parse(configURL)
+	return r.baseURL()
+}
+
+func (r *Runner) baseURL() (*url.URL, error) {
+	if active, ok := r.activeMirror(); ok {
+		return url.Parse(active)
+	}
+
+	mirrors := r.mirrors()
+	if len(mirrors) == 0 {
+		return nil, errors.New("No links or url configured for site")
 	}
 
-	return url.Parse(r.Definition.Links[0])
+	return url.Parse(mirrors[0])
 }
 
 func (r *Runner) resolvePath(urlPath string) (string, error) {
@@ -98,7 +120,7 @@ func (r *Runner) resolvePath(urlPath string) (string, error) {
 func (r *Runner) openPage(u string) error {
 	r.Logger.WithField("url", u).Debug("Attempting to open page")
 
-	err := r.Browser.Open(u)
+	err := r.request(u, r.Browser.Open)
 	if err != nil {
 		return err
 	}
@@ -123,56 +145,270 @@ func (r *Runner) openPage(u string) error {
 	return nil
 }
 
-func (r *Runner) Login() error {
-	filterLogger = r.Logger
-	filterCategoryMapping = r.Capabilities().Categories
+// filterContext returns the context that filters applied by this runner are invoked with
+func (r *Runner) filterContext() FilterContext {
+	ctx := FilterContext{
+		Logger:     r.Logger,
+		Categories: r.Capabilities().Categories,
+	}
 
-	loginUrl, err := r.resolvePath(r.Definition.Login.Path)
-	if err != nil {
+	// the timezone is validated when the definition is parsed
+	if r.Definition.Timezone != "" {
+		ctx.Location, _ = time.LoadLocation(r.Definition.Timezone)
+	}
+
+	return ctx
+}
+
+func (r *Runner) Login() error {
+	if err := r.checkSettings(); err != nil {
 		return err
 	}
 
-	if err = r.openPage(loginUrl); err != nil {
+	// passkey sites have no login, the passkey is sent with .Config in the search templates
+	if r.Definition.Login.Method == loginMethodPasskey {
+		r.Logger.Debug("Site uses a passkey, no login required")
+		return nil
+	}
+
+	if err := r.loadCookies(); err != nil {
 		return err
 	}
 
-	fm, err := r.Browser.Form(r.Definition.Login.FormSelector)
+	ctx, err := r.loginInputContext()
 	if err != nil {
 		return err
 	}
 
-	for name, val := range r.Definition.Login.Inputs {
-		r.Logger.
-			WithFields(logrus.Fields{"key": name, "form": r.Definition.Login.FormSelector, "val": val}).
-			Debugf("Filling input of form")
+	removeHeaders, err := r.applyHeaders("login_headers", r.Definition.Login.Headers, ctx)
+	if err != nil {
+		return err
+	}
+	defer removeHeaders()
 
-		cfg, err := r.Config.Section(r.Definition.Site)
+	if !r.Definition.Login.Test.IsEmpty() {
+		ok, err := r.isLoggedIn()
 		if err != nil {
 			return err
 		}
+		if ok {
+			r.Logger.Info("Existing session is still valid, skipping login")
+			return nil
+		}
+	}
 
-		resolved, err := r.applyTemplate("login_inputs", val, struct {
-			Config map[string]string
-		}{
-			cfg,
-		})
-		if err != nil {
-			return err
+	switch r.Definition.Login.Method {
+	case loginMethodForm:
+		err = r.loginViaForm()
+	case loginMethodPost:
+		err = r.loginViaPost()
+	case loginMethodCookie:
+		err = r.loginViaCookie()
+	case loginMethodBasic:
+		err = r.loginViaBasicAuth()
+	default:
+		err = fmt.Errorf("Unknown login method %q", r.Definition.Login.Method)
+	}
+
+	if err == nil && !r.Definition.Login.Test.IsEmpty() {
+		if ok, testErr := r.isLoggedIn(); testErr != nil {
+			err = testErr
+		} else if !ok {
+			err = errors.New("Login test failed after logging in")
+		}
+	}
+
+	if err != nil {
+		r.Logger.WithError(err).Error("Failed to login")
+		return err
+	}
+
+	if err = r.saveCookies(); err != nil {
+		r.Logger.WithError(err).Warn("Failed to save cookies")
+	}
+
+	r.Logger.Info("Successfully logged in")
+	return nil
+}
+
+// isLoggedIn opens the login test page and checks whether the current session is valid
+func (r *Runner) isLoggedIn() (bool, error) {
+	testUrl, err := r.resolvePath(r.Definition.Login.Test.Path)
+	if err != nil {
+		return false, err
+	}
+
+	if err = r.openPage(testUrl); err != nil {
+		return false, err
+	}
+
+	if r.Definition.Login.Test.Selector != "" {
+		return r.Browser.Find(r.Definition.Login.Test.Selector).Length() > 0, nil
+	}
+
+	// without a selector, being redirected away from the test page means we aren't logged in
+	u, err := url.Parse(testUrl)
+	if err != nil {
+		return false, err
+	}
+
+	return r.Browser.Url().Path == u.Path, nil
+}
+
+func (r *Runner) loadCookies() error {
+	if r.CookieStore == nil || r.cookiesLoaded {
+		return nil
+	}
+
+	cookies, err := r.CookieStore.Load(r.Definition.Site)
+	if err != nil {
+		return err
+	}
+
+	u, err := r.baseURL()
+	if err != nil {
+		return err
+	}
+
+	r.Logger.
+		WithFields(logrus.Fields{"count": len(cookies)}).
+		Debug("Loaded stored cookies")
+
+	r.Browser.CookieJar().SetCookies(u, cookies)
+	r.cookiesLoaded = true
+	return nil
+}
+
+func (r *Runner) saveCookies() error {
+	if r.CookieStore == nil {
+		return nil
+	}
+
+	u, err := r.baseURL()
+	if err != nil {
+		return err
+	}
+
+	// the recorder keeps the expiry, domain and path that the jar discards
+	var cookies []*http.Cookie
+	if recorder, ok := r.Browser.CookieJar().(*cookieRecorder); ok {
+		cookies = recorder.recorded()
+	} else {
+		cookies = r.Browser.CookieJar().Cookies(u)
+	}
+
+	r.Logger.
+		WithFields(logrus.Fields{"count": len(cookies)}).
+		Debug("Saving cookies")
+
+	return r.CookieStore.Save(r.Definition.Site, cookies)
+}
+
+// settings returns the config for the site with defaults applied for missing settings
+func (r *Runner) settings() (map[string]string, error) {
+	section, err := r.Config.Section(r.Definition.Site)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := map[string]string{}
+	for _, setting := range r.Definition.Settings {
+		if setting.Default != "" {
+			cfg[setting.Name] = setting.Default
+		}
+	}
+	for k, v := range section {
+		cfg[k] = v
+	}
+
+	return cfg, nil
+}
+
+// checkSettings ensures that all the required settings for the site have values
+func (r *Runner) checkSettings() error {
+	cfg, err := r.settings()
+	if err != nil {
+		return err
+	}
+
+	for _, setting := range r.Definition.Settings {
+		if setting.Required && cfg[setting.Name] == "" {
+			return fmt.Errorf("Missing required setting %q for %s", setting.Name, r.Definition.Site)
 		}
+	}
+
+	return nil
+}
+
+func (r *Runner) loginInputContext() (interface{}, error) {
+	cfg, err := r.settings()
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Config map[string]string
+	}{
+		cfg,
+	}, nil
+}
+
+func (r *Runner) loginInputs() (map[string]string, error) {
+	ctx, err := r.loginInputContext()
+	if err != nil {
+		return nil, err
+	}
+
+	vals, err := r.resolveInputs("login_inputs", r.Definition.Login.Inputs, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]string{}
+	for name := range vals {
+		result[name] = vals.Get(name)
+	}
+
+	return result, nil
+}
+
+func (r *Runner) loginViaForm() error {
+	loginUrl, err := r.resolvePath(r.Definition.Login.Path)
+	if err != nil {
+		return err
+	}
+
+	if err = r.openPage(loginUrl); err != nil {
+		return err
+	}
 
+	fm, err := r.Browser.Form(r.Definition.Login.FormSelector)
+	if err != nil {
+		return err
+	}
+
+	inputs, err := r.loginInputs()
+	if err != nil {
+		return err
+	}
+
+	for name, val := range inputs {
 		r.Logger.
-			WithFields(logrus.Fields{"key": name, "form": r.Definition.Login.FormSelector, "val": resolved}).
-			Debugf("Resolved input template")
+			WithFields(logrus.Fields{"key": name, "form": r.Definition.Login.FormSelector}).
+			Debugf("Filling input of form")
 
-		if err = fm.Input(name, resolved); err != nil {
+		if err = fm.Input(name, val); err != nil {
 			return err
 		}
 	}
 
 	r.Logger.Debug("Submitting login form")
 
+	if err = r.waitForRequest(); err != nil {
+		return err
+	}
+
 	if err = fm.Submit(); err != nil {
-		r.Logger.WithError(err).Error("Login failed")
 		return err
 	}
 
@@ -180,15 +416,108 @@ func (r *Runner) Login() error {
 		WithFields(logrus.Fields{"code": r.Browser.StatusCode(), "page": r.Browser.Url()}).
 		Debugf("Finished request")
 
-	if err = r.Definition.Login.hasError(r.Browser); err != nil {
-		r.Logger.WithError(err).Error("Failed to login")
+	return r.Definition.Login.hasError(r.filterContext(), r.Browser)
+}
+
+func (r *Runner) loginViaPost() error {
+	loginUrl, err := r.resolvePath(r.Definition.Login.Path)
+	if err != nil {
 		return err
 	}
 
-	r.Logger.Info("Successfully logged in")
+	inputs, err := r.loginInputs()
+	if err != nil {
+		return err
+	}
+
+	vals := url.Values{}
+	for name, val := range inputs {
+		vals.Set(name, val)
+	}
+
+	r.Logger.
+		WithFields(logrus.Fields{"page": loginUrl}).
+		Debug("Posting login inputs")
+
+	err = r.request(loginUrl, func(u string) error {
+		return r.Browser.PostForm(u, vals)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.Logger.
+		WithFields(logrus.Fields{"code": r.Browser.StatusCode(), "page": r.Browser.Url()}).
+		Debugf("Finished request")
+
+	return r.Definition.Login.hasError(r.filterContext(), r.Browser)
+}
+
+func (r *Runner) loginViaCookie() error {
+	tpl := r.Definition.Login.Cookie
+	if tpl == "" {
+		tpl = defaultLoginCookie
+	}
+
+	ctx, err := r.loginInputContext()
+	if err != nil {
+		return err
+	}
+
+	cookieStr, err := r.applyTemplate("login_cookie", tpl, ctx)
+	if err != nil {
+		return err
+	}
+
+	cookies := parseCookieString(cookieStr)
+	if len(cookies) == 0 {
+		return errors.New("No cookies were provided for login")
+	}
+
+	u, err := r.currentURL()
+	if err != nil {
+		return err
+	}
+
+	r.Logger.
+		WithFields(logrus.Fields{"url": u.String(), "count": len(cookies)}).
+		Debug("Setting login cookies")
+
+	r.Browser.CookieJar().SetCookies(u, cookies)
+	return nil
+}
+
+// loginViaBasicAuth sends the username and password login inputs as the credentials of
+// an Authorization header on every request
+func (r *Runner) loginViaBasicAuth() error {
+	inputs, err := r.loginInputs()
+	if err != nil {
+		return err
+	}
+
+	auth := base64.StdEncoding.EncodeToString([]byte(inputs["username"] + ":" + inputs["password"]))
+
+	r.Logger.Debug("Setting basic auth header")
+	r.Browser.DelRequestHeader("Authorization")
+	r.Browser.AddRequestHeader("Authorization", "Basic "+auth)
 	return nil
 }
 
+// parseCookieString parses a cookie header style string like "uid=1; pass=abc"
+func parseCookieString(s string) []*http.Cookie {
+	cookies := []*http.Cookie{}
+
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		cookies = append(cookies, &http.Cookie{Name: kv[0], Value: kv[1]})
+	}
+
+	return cookies
+}
+
 func (r *Runner) Info() torznab.Info {
 	return torznab.Info{
 		ID:       r.Definition.Site,
@@ -198,7 +527,9 @@ func (r *Runner) Info() torznab.Info {
 }
 
 func (r *Runner) Test() error {
-	filterLogger = r.Logger
+	if err := checkMirrorResults(r.CheckMirrors()); err != nil {
+		return err
+	}
 
 	for _, mode := range r.Capabilities().SearchModes {
 		query := torznab.Query{
@@ -236,7 +567,7 @@ func (r *Runner) Test() error {
 			if result.Site == "" {
 				return fmt.Errorf("Result row %d has blank site", idx+1)
 			}
-			if result.Category == 0 {
+			if len(result.Categories) == 0 {
 				return fmt.Errorf("Result row %d has blank category", idx+1)
 			}
 		}
@@ -250,106 +581,503 @@ func (r *Runner) Capabilities() torznab.Capabilities {
 }
 
 func (r *Runner) Search(query torznab.Query) ([]torznab.ResultItem, error) {
-	filterLogger = r.Logger
-	filterCategoryMapping = r.Capabilities().Categories
-
-	searchUrl, err := r.resolvePath(r.Definition.Search.Path)
-	if err != nil {
+	if err := r.loadCookies(); err != nil {
 		return nil, err
 	}
 
-	r.Logger.
-		WithFields(logrus.Fields{"query": query}).
-		Infof("Searching indexer")
+	items, err := r.search(query)
+	if err != errLoggedOut {
+		return items, err
+	}
 
-	if err := r.openPage(searchUrl); err != nil {
+	r.Logger.Info("Session has expired, logging in again")
+
+	if err = r.Login(); err != nil {
 		return nil, err
 	}
 
-	localCats := []int{}
+	items, err = r.search(query)
+	if err == errLoggedOut {
+		return nil, errors.New("Still logged out after logging in again")
+	}
+
+	return items, err
+}
+
+func (r *Runner) search(query torznab.Query) ([]torznab.ResultItem, error) {
+	localCats := []string{}
 
 	if unmappedCats, ok := query["cat"].([]int); ok {
 		localCats = r.Capabilities().Categories.ReverseMap(unmappedCats)
 	}
 
-	inputCtx := struct {
-		Query      torznab.Query
-		Categories []int
-	}{
-		query,
-		localCats,
+	keywords, err := applyFilterBlocks(r.filterContext(), r.Definition.Search.KeywordsFilters, query.Keywords())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to filter keywords: %s", err.Error())
 	}
 
-	vals := url.Values{}
-
-	for name, val := range r.Definition.Search.Inputs {
-		resolved, err := r.applyTemplate("search_inputs", val, inputCtx)
-		if err != nil {
-			return nil, err
-		}
-		switch name {
-		case "$raw":
-			parsedVals, err := url.ParseQuery(resolved)
-			if err != nil {
-				return nil, fmt.Errorf("Error parsing $raw input: %s", err.Error())
-			}
-
-			r.Logger.
-				WithFields(logrus.Fields{"source": val, "parsed": parsedVals}).
-				Infof("Processed $raw input")
-
-			for k, values := range parsedVals {
-				for _, val := range values {
-					vals.Add(k, val)
-				}
-			}
-		default:
-			vals.Add(name, resolved)
-		}
+	cfg, err := r.settings()
+	if err != nil {
+		return nil, err
 	}
 
-	r.Logger.
-		WithFields(logrus.Fields{"params": vals, "page": searchUrl}).
-		Debugf("Submitting page with form params")
+	inputCtx := searchContext{
+		Config:     cfg,
+		Query:      query,
+		Keywords:   keywords,
+		Categories: localCats,
+	}
 
-	err = r.Browser.OpenForm(searchUrl, vals)
+	removeHeaders, err := r.applyHeaders("search_headers", r.Definition.Search.Headers, inputCtx)
 	if err != nil {
 		return nil, err
 	}
+	defer removeHeaders()
 
 	r.Logger.
-		WithFields(logrus.Fields{"code": r.Browser.StatusCode(), "page": r.Browser.Url()}).
-		Debugf("Finished opening form")
+		WithFields(logrus.Fields{"query": query}).
+		Infof("Searching indexer")
 
 	items := []torznab.ResultItem{}
 	timer := time.Now()
-	rows := r.Browser.Find(r.Definition.Search.Rows.Selector)
-	limit, hasLimit := query["limit"].(int)
+	seen := map[string]bool{}
+	paths := r.searchPaths(query, localCats)
 
-	r.Logger.
-		WithFields(logrus.Fields{"rows": rows.Length(), "selector": r.Definition.Search.Rows.Selector}).
-		Debugf("Found %d rows", rows.Length())
+	offset, _ := query["offset"].(int)
+	limit, hasLimit := query["limit"].(int)
+	if !hasLimit {
+		limit = -1
+	}
 
-	for i := 0; i < rows.Length() && (!hasLimit || len(items) < limit); i++ {
-		row := map[string]string{}
+	// the offset and limit apply to the merged results of several paths, so each path
+	// has to return all of the results up to the end of the limit
+	pathOffset, pathLimit := offset, limit
+	if len(paths) > 1 {
+		pathOffset = 0
+		if hasLimit {
+			pathLimit = offset + limit
+		}
+	}
 
-		for field, block := range r.Definition.Search.Fields {
-			r.Logger.
-				WithFields(logrus.Fields{"row": i + 1, "block": block}).
-				Debugf("Processing field %q", field)
+	for _, path := range paths {
+		pathItems, err := r.searchPath(path, query, inputCtx, pathOffset, pathLimit)
+		if err != nil {
+			return nil, err
+		}
 
-			val, err := block.Text(rows.Eq(i))
-			if err != nil {
-				return nil, err
+		// results from several paths are merged, skipping any that appear in more than one
+		for _, item := range pathItems {
+			if item.Link != "" {
+				if seen[item.Link] {
+					continue
+				}
+				seen[item.Link] = true
 			}
-
-			r.Logger.
+			items = append(items, item)
+		}
+	}
+
+	if pathOffset != offset {
+		if offset >= len(items) {
+			items = []torznab.ResultItem{}
+		} else {
+			items = items[offset:]
+		}
+	}
+
+	if hasLimit && len(items) > limit {
+		items = items[:limit]
+	}
+
+	r.Logger.WithFields(logrus.Fields{"time": time.Now().Sub(timer)}).Infof("Query returned %d results", len(items))
+	return items, nil
+}
+
+// searchPath searches a single path of the site, following pages of results until the
+// results after the offset reach the limit, a negative limit meaning there isn't one
+func (r *Runner) searchPath(path string, query torznab.Query, inputCtx searchContext, offset, limit int) ([]torznab.ResultItem, error) {
+	searchPath, err := r.applyTemplate("search_path", path, inputCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	searchUrl, err := r.resolvePath(searchPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.openPage(searchUrl); err != nil {
+		return nil, err
+	}
+
+	vals, err := r.resolveInputs("search_inputs", r.Definition.Search.Inputs, inputCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []torznab.ResultItem{}
+	hasLimit := limit >= 0
+	paging := r.Definition.Search.Paging
+	seen := map[string]bool{}
+	freeleechOnly := r.freeleechOnly(query)
+	_, hasCats := query["cat"].([]int)
+
+	// with a known page size we can jump straight to the page containing the offset, but
+	// only when no rows are filtered out, as the offset counts results rather than rows
+	pageIdx := 0
+	if paging.PageSize > 0 && len(paging.Inputs) > 0 && !hasCats && !freeleechOnly {
+		pageIdx = offset / paging.PageSize
+		offset -= pageIdx * paging.PageSize
+	}
+
+	nextURL := ""
+
+	for pageCount := 1; ; pageCount++ {
+		if nextURL != "" {
+			if err := r.openPage(nextURL); err != nil {
+				return nil, err
+			}
+		} else {
+			pageVals, err := r.pageInputs(vals, inputCtx, pageIdx)
+			if err != nil {
+				return nil, err
+			}
+
+			r.Logger.
+				WithFields(logrus.Fields{"params": pageVals, "page": searchUrl}).
+				Debugf("Submitting page with form params")
+
+			err = r.request(searchUrl, func(u string) error {
+				return r.submitSearch(u, pageVals)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		r.Logger.
+			WithFields(logrus.Fields{"code": r.Browser.StatusCode(), "page": r.Browser.Url()}).
+			Debugf("Finished opening form")
+
+		if r.Definition.Search.LoggedOut.matchPage(r.Browser) {
+			return nil, errLoggedOut
+		}
+
+		rows, err := r.resultRows()
+		if err != nil {
+			return nil, err
+		}
+
+		r.Logger.
+			WithFields(logrus.Fields{"rows": len(rows), "selector": r.Definition.Search.Rows.Selector}).
+			Debugf("Found %d rows", len(rows))
+
+		pageItems, lastPage, err := r.extractItems(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		// paging stops when a page has no new rows, even if they were all filtered out
+		newRows := 0
+		for _, item := range pageItems {
+			if item.Link != "" {
+				if seen[item.Link] {
+					continue
+				}
+				seen[item.Link] = true
+			}
+			newRows++
+
+			if !r.matchesQuery(item, query, freeleechOnly) {
+				continue
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if hasLimit && len(items) >= limit {
+				break
+			}
+			items = append(items, item)
+		}
+
+		if paging.IsEmpty() || lastPage || newRows == 0 {
+			break
+		} else if hasLimit && len(items) >= limit {
+			break
+		} else if !hasLimit && offset == 0 {
+			break
+		} else if pageCount >= paging.maxPages() {
+			r.Logger.Debugf("Stopping after reaching maximum of %d pages", pageCount)
+			break
+		}
+
+		nextURL = ""
+		if !paging.Next.IsEmpty() {
+			link, err := paging.Next.Text(r.filterContext(), r.Browser.Dom())
+			if err != nil {
+				return nil, err
+			}
+			if link == "" {
+				r.Logger.Debug("No next page link found")
+				break
+			}
+			if nextURL, err = r.resolvePath(link); err != nil {
+				return nil, err
+			}
+		} else {
+			pageIdx++
+		}
+	}
+
+	return items, nil
+}
+
+// searchPaths returns the paths to search for the requested categories, paths without
+// categories are always searched
+func (r *Runner) searchPaths(query torznab.Query, localCats []string) []string {
+	if len(r.Definition.Search.Paths) == 0 {
+		return []string{r.Definition.Search.Path}
+	}
+
+	cats, hasCats := query["cat"].([]int)
+	paths := []string{}
+
+	for _, p := range r.Definition.Search.Paths {
+		if !hasCats || len(cats) == 0 || len(p.Categories) == 0 || p.matches(cats, localCats) {
+			paths = append(paths, p.Path)
+		}
+	}
+
+	return paths
+}
+
+// resolveInputs applies templates to the inputs and returns them as form values
+func (r *Runner) resolveInputs(tplName string, inputs inputsBlock, ctx interface{}) (url.Values, error) {
+	vals := url.Values{}
+
+	for name, input := range inputs {
+		resolvedVals, err := r.resolveInput(tplName, input, ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Logger.
+			WithFields(logrus.Fields{"key": name, "vals": resolvedVals}).
+			Debugf("Resolved input template")
+
+		for _, resolved := range resolvedVals {
+			switch name {
+			case "$raw":
+				parsedVals, err := url.ParseQuery(resolved)
+				if err != nil {
+					return nil, fmt.Errorf("Error parsing $raw input: %s", err.Error())
+				}
+
+				r.Logger.
+					WithFields(logrus.Fields{"source": input.Value, "parsed": parsedVals}).
+					Infof("Processed $raw input")
+
+				for k, values := range parsedVals {
+					for _, val := range values {
+						vals.Add(k, val)
+					}
+				}
+			default:
+				vals.Add(name, resolved)
+			}
+		}
+	}
+
+	return vals, nil
+}
+
+// submitSearch sends the search form values with the definition's search method
+func (r *Runner) submitSearch(u string, vals url.Values) error {
+	switch r.Definition.Search.Method {
+	case searchMethodPost:
+		return r.Browser.PostForm(u, vals)
+
+	case searchMethodJSONPost:
+		data := map[string]interface{}{}
+		for k, v := range vals {
+			if len(v) == 1 {
+				data[k] = v[0]
+			} else {
+				data[k] = v
+			}
+		}
+
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		return r.Browser.Post(u, "application/json", bytes.NewReader(b))
+	}
+
+	return r.Browser.OpenForm(u, vals)
+}
+
+// applyHeaders adds the templated headers to the requests made by the browser, the
+// returned func removes them again
+func (r *Runner) applyHeaders(tplName string, headers headersBlock, ctx interface{}) (func(), error) {
+	names := []string{}
+	removeHeaders := func() {
+		for _, name := range names {
+			r.Browser.DelRequestHeader(name)
+		}
+	}
+
+	for name, tpl := range headers {
+		val, err := r.applyTemplate(tplName, tpl, ctx)
+		if err != nil {
+			removeHeaders()
+			return nil, err
+		}
+
+		r.Logger.
+			WithFields(logrus.Fields{"header": name}).
+			Debugf("Adding request header")
+
+		r.Browser.AddRequestHeader(name, val)
+		names = append(names, name)
+	}
+
+	return removeHeaders, nil
+}
+
+// searchContext is the data that search and paging input templates are applied to.
+// Keywords are the query keywords after the definition's keywordsfilters are applied,
+// whereas .Query.Keywords and .Query.q are left as they were requested, so inputs
+// should use .Keywords for the filters to apply. Config has the site's settings with
+// defaults applied, as it does when logging in.
+type searchContext struct {
+	Config     map[string]string
+	Query      torznab.Query
+	Keywords   string
+	Categories []string
+	Category   string
+	Page       int
+	Offset     int
+}
+
+// resolveInput applies the template of an input, returning no values if its condition
+// isn't met or it's empty and marked omitempty, or one value per category if it's marked
+// percategory
+func (r *Runner) resolveInput(tplName string, input inputBlock, ctx interface{}) ([]string, error) {
+	if input.If != "" {
+		cond, err := r.applyTemplate(tplName+"_if", input.If, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if cond = strings.TrimSpace(cond); cond == "" || cond == "false" {
+			return nil, nil
+		}
+	}
+
+	ctxs := []interface{}{ctx}
+
+	if input.PerCategory {
+		searchCtx, ok := ctx.(searchContext)
+		if !ok {
+			return nil, errors.New("Inputs repeated per category are only supported when searching")
+		}
+		ctxs = []interface{}{}
+		for _, cat := range searchCtx.Categories {
+			catCtx := searchCtx
+			catCtx.Category = cat
+			ctxs = append(ctxs, catCtx)
+		}
+	}
+
+	results := []string{}
+
+	for _, c := range ctxs {
+		resolved, err := r.applyTemplate(tplName, input.Value, c)
+		if err != nil {
+			return nil, err
+		}
+		if resolved == "" && input.OmitEmpty {
+			continue
+		}
+		results = append(results, resolved)
+	}
+
+	return results, nil
+}
+
+// pageInputs returns the search form values with the paging inputs for the page index added
+func (r *Runner) pageInputs(vals url.Values, inputCtx searchContext, pageIdx int) (url.Values, error) {
+	paging := r.Definition.Search.Paging
+	if len(paging.Inputs) == 0 {
+		return vals, nil
+	}
+
+	inputCtx.Page = paging.PageStart + pageIdx
+	inputCtx.Offset = pageIdx * paging.PageSize
+
+	pageVals, err := r.resolveInputs("paging_inputs", paging.Inputs, inputCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := url.Values{}
+	for k, v := range vals {
+		merged[k] = append([]string{}, v...)
+	}
+	for k, v := range pageVals {
+		merged[k] = v
+	}
+
+	return merged, nil
+}
+
+// extractItems converts the result rows of a page into items, lastPage is true when a row
+// indicates there are no further results
+func (r *Runner) extractItems(rows []resultRow) (items []torznab.ResultItem, lastPage bool, err error) {
+	filterCtx := r.filterContext()
+
+	for i := 0; i < len(rows); i++ {
+		row := map[string]string{}
+
+		for field, block := range r.Definition.Search.Fields {
+			r.Logger.
+				WithFields(logrus.Fields{"row": i + 1, "block": block}).
+				Debugf("Processing field %q", field)
+
+			val, err := rows[i].fieldText(filterCtx, block)
+			if err != nil && !block.Optional {
+				return nil, false, err
+			} else if err != nil {
+				r.Logger.
+					WithFields(logrus.Fields{"row": i + 1}).
+					WithError(err).
+					Debugf("Optional field %q failed, using default", field)
+				val = ""
+			}
+
+			if val == "" {
+				val = block.Default
+			}
+
+			r.Logger.
 				WithFields(logrus.Fields{"row": i + 1, "output": val}).
 				Debugf("Finished processing field %q", field)
 
+			// optional fields without a value are left out rather than failing to parse
+			if val == "" && block.Optional {
+				continue
+			}
+
 			row[field] = val
 		}
 
+		// results under a date header take the header's date unless they have their own
+		if date := rows[i].headerDate(); date != "" && row["date"] == "" {
+			row["date"] = date
+		}
+
 		item := torznab.ResultItem{
 			Site:            r.Definition.Site,
 			MinimumRatio:    1,
@@ -363,6 +1091,13 @@ func (r *Runner) Search(query torznab.Query) ([]torznab.ResultItem, error) {
 		for key, val := range row {
 			switch key {
 			case "download":
+				if IsMagnetLink(val) {
+					item.Link = val
+					if item.MagnetLink == "" {
+						item.MagnetLink = val
+					}
+					continue
+				}
 				u, err := r.resolvePath(val)
 				if err != nil {
 					r.Logger.Warnf("Search result row #%d has malformed url in %s", i+1, key)
@@ -388,12 +1123,14 @@ func (r *Runner) Search(query torznab.Query) ([]torznab.ResultItem, error) {
 			case "description":
 				item.Description = val
 			case "category":
-				catID, err := strconv.Atoi(val)
-				if err != nil {
-					r.Logger.Warnf("Search result row #%d has malformed categoryid: %s", i+1, err.Error())
-					continue
+				for _, catVal := range strings.Split(val, ",") {
+					catID, err := strconv.Atoi(strings.TrimSpace(catVal))
+					if err != nil {
+						r.Logger.Warnf("Search result row #%d has malformed categoryid: %s", i+1, err.Error())
+						continue
+					}
+					item.Categories = append(item.Categories, catID)
 				}
-				item.Category = catID
 			case "size":
 				bytes, err := humanize.ParseBytes(val)
 				if err != nil {
@@ -422,44 +1159,265 @@ func (r *Runner) Search(query torznab.Query) ([]torznab.ResultItem, error) {
 					r.Logger.Warnf("Search result row #%d has malformed time value in %s", i+1, key)
 					continue
 				}
-				item.PublishDate = t
+				item.PublishDate = t.UTC()
+			case "infohash":
+				item.InfoHash = strings.ToLower(val)
+			case "magnet":
+				if !IsMagnetLink(val) {
+					r.Logger.Warnf("Search result row #%d has malformed magnet link in %s", i+1, key)
+					continue
+				}
+				item.MagnetLink = val
+				if item.Link == "" {
+					item.Link = val
+				}
+			case "grabs", "files":
+				n, err := strconv.Atoi(val)
+				if err != nil {
+					r.Logger.Warnf("Search result row #%d has malformed %s value", i+1, key)
+					continue
+				}
+				if key == "grabs" {
+					item.Grabs = n
+				} else {
+					item.Files = n
+				}
+			case "imdb":
+				imdb, err := parseIMDBId(val)
+				if err != nil {
+					r.Logger.Warnf("Search result row #%d has malformed imdb id: %s", i+1, err.Error())
+					continue
+				}
+				item.IMDB = imdb
+			case "tvdbid", "tvrageid":
+				id, err := strconv.Atoi(val)
+				if err != nil {
+					r.Logger.Warnf("Search result row #%d has malformed %s value", i+1, key)
+					continue
+				}
+				if key == "tvdbid" {
+					item.TVDBId = id
+				} else {
+					item.TVRageId = id
+				}
+			case "banner", "poster":
+				u, err := r.resolvePath(val)
+				if err != nil {
+					r.Logger.Warnf("Search result row #%d has malformed url in %s", i+1, key)
+					continue
+				}
+				if key == "banner" {
+					item.Banner = u
+				} else {
+					item.Poster = u
+				}
+			case "downloadvolumefactor", "uploadvolumefactor":
+				factor, err := strconv.ParseFloat(val, 64)
+				if err != nil || factor < 0 {
+					r.Logger.Warnf("Search result row #%d has malformed %s value", i+1, key)
+					continue
+				}
+				if key == "downloadvolumefactor" {
+					item.DownloadVolumeFactor = &factor
+				} else {
+					item.UploadVolumeFactor = &factor
+				}
 			default:
-				return nil, fmt.Errorf("Unknown field %q", key)
+				return nil, false, fmt.Errorf("Unknown field %q", key)
 			}
 		}
 
-		skipItem := false
-
 		// some trackers have empty rows when there are no results
 		if item.Title == "" {
-			return nil, nil
+			return items, true, nil
 		}
 
-		// some trackers don't support filtering by categories, so do it for them
-		if catFilters, hasCats := query["cat"].([]int); hasCats {
-			var catMatch bool
-			for _, catId := range catFilters {
-				r.Logger.Debugf("Checking item cat %d against query cat %d", item.Category, catId)
-				if catId == item.Category {
+		items = append(items, item)
+	}
+
+	return items, false, nil
+}
+
+// matchesQuery returns whether an item is in the categories of the query and is freeleech
+// when only freeleech results are wanted
+func (r *Runner) matchesQuery(item torznab.ResultItem, query torznab.Query, freeleechOnly bool) bool {
+	// some trackers don't support filtering by categories, so do it for them
+	if catFilters, hasCats := query["cat"].([]int); hasCats {
+		var catMatch bool
+		for _, catId := range catFilters {
+			r.Logger.Debugf("Checking item cats %v against query cat %d", item.Categories, catId)
+			for _, itemCat := range item.Categories {
+				if catId == itemCat {
 					catMatch = true
 				}
 			}
-			if !catMatch {
-				r.Logger.Debugf("Skipping row due to non-matching category")
-				skipItem = skipItem || !catMatch
+		}
+		if !catMatch {
+			r.Logger.Debugf("Skipping row due to non-matching category")
+			return false
+		}
+	}
+
+	if freeleechOnly && (item.DownloadVolumeFactor == nil || *item.DownloadVolumeFactor != 0) {
+		r.Logger.Debugf("Skipping row that isn't freeleech")
+		return false
+	}
+
+	return true
+}
+
+// IsMagnetLink returns whether a result link is a magnet link rather than a url to download
+func IsMagnetLink(link string) bool {
+	return torznab.IsMagnetLink(link)
+}
+
+// freeleechOnly returns whether only freeleech results should be returned, either because
+// the query asked for them or the indexer is configured to
+func (r *Runner) freeleechOnly(query torznab.Query) bool {
+	if freeleech, ok := query["freeleech"].(bool); ok {
+		return freeleech
+	}
+
+	if val, ok, _ := r.Config.Get(r.Definition.Site, "freeleech"); ok {
+		freeleech, _ := strconv.ParseBool(val)
+		return freeleech
+	}
+
+	return false
+}
+
+var imdbIDPattern = regexp.MustCompile(`(?:^|tt)(\d+)`)
+
+// parseIMDBId extracts the numeric id from an imdb id or url, e.g "tt0133093" or
+// "http://www.imdb.com/title/tt0133093/"
+func parseIMDBId(val string) (int, error) {
+	matches := imdbIDPattern.FindStringSubmatch(val)
+	if matches == nil {
+		return 0, fmt.Errorf("No imdb id found in %q", val)
+	}
+	return strconv.Atoi(matches[1])
+}
+
+// resultRow is a single result extracted from a search response
+type resultRow interface {
+	fieldText(ctx FilterContext, block selectorBlock) (string, error)
+	headerDate() string
+}
+
+type htmlRow struct {
+	selection *goquery.Selection
+	date      string
+}
+
+func (h htmlRow) fieldText(ctx FilterContext, block selectorBlock) (string, error) {
+	return block.Text(ctx, h.selection)
+}
+
+func (h htmlRow) headerDate() string {
+	return h.date
+}
+
+type jsonRow struct {
+	data interface{}
+}
+
+func (j jsonRow) fieldText(ctx FilterContext, block selectorBlock) (string, error) {
+	return block.JSONText(ctx, j.data)
+}
+
+func (j jsonRow) headerDate() string {
+	return ""
+}
+
+// resultRows splits the current page into rows according to the search response type
+func (r *Runner) resultRows() ([]resultRow, error) {
+	rows := []resultRow{}
+
+	switch r.Definition.Search.Response.Type {
+	case responseTypeJSON:
+		data, err := decodeJSON(r.Browser.Body())
+		if err != nil {
+			return nil, err
+		}
+
+		matched, err := jsonPath(data, r.Definition.Search.Rows.Selector)
+		if err == errJSONPathNotFound {
+			return rows, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch t := matched.(type) {
+		case []interface{}:
+			for _, item := range t {
+				rows = append(rows, jsonRow{item})
+			}
+		case nil:
+		default:
+			return nil, fmt.Errorf("JSON path %q for rows doesn't refer to an array", r.Definition.Search.Rows.Selector)
+		}
+
+	default:
+		return r.htmlRows(r.Browser.Find(r.Definition.Search.Rows.Selector))
+	}
+
+	return rows, nil
+}
+
+// htmlRows groups the matched elements into results, skipping excluded rows and date
+// headers and merging each result with the sibling rows that follow it
+func (r *Runner) htmlRows(selection *goquery.Selection) ([]resultRow, error) {
+	rowsBlock := r.Definition.Search.Rows
+	filterCtx := r.filterContext()
+	rows := []resultRow{}
+
+	for i := 0; i < selection.Length(); i++ {
+		row := selection.Eq(i)
+
+		if rowsBlock.Exclude != "" && row.Is(rowsBlock.Exclude) {
+			r.Logger.WithFields(logrus.Fields{"row": i + 1}).Debugf("Excluding row")
+			continue
+		} else if rowsBlock.DateHeaders.Selector != "" && row.Is(rowsBlock.DateHeaders.Selector) {
+			continue
+		}
+
+		next := row
+		for j := 0; j < rowsBlock.After; j++ {
+			next = next.Next()
+			if next.Length() == 0 {
+				break
 			}
+			row = row.AddSelection(next)
 		}
 
-		if !skipItem {
-			items = append(items, item)
+		// rows merged into this result aren't results of their own
+		for i+1 < selection.Length() && selection.Eq(i+1).IsSelection(row) {
+			i++
 		}
+
+		var date string
+		if rowsBlock.DateHeaders.Selector != "" {
+			header := row.First().PrevAll().Filter(rowsBlock.DateHeaders.Selector).First()
+			if header.Length() > 0 {
+				var err error
+				date, err = rowsBlock.DateHeaders.applyFilters(filterCtx, strings.TrimSpace(header.Text()))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		rows = append(rows, htmlRow{row, date})
 	}
 
-	r.Logger.WithFields(logrus.Fields{"time": time.Now().Sub(timer)}).Infof("Query returned %d results", len(items))
-	return items, nil
+	return rows, nil
 }
 
 func (r *Runner) Download(u string) (io.ReadCloser, http.Header, error) {
+	if IsMagnetLink(u) {
+		return nil, http.Header{}, errors.New("Magnet links can't be downloaded")
+	}
+
 	if err := r.Login(); err != nil {
 		return nil, http.Header{}, err
 	}
@@ -469,10 +1427,26 @@ func (r *Runner) Download(u string) (io.ReadCloser, http.Header, error) {
 		return nil, http.Header{}, err
 	}
 
-	if err := r.Browser.Open(fullUrl); err != nil {
+	if err := r.request(fullUrl, r.Browser.Open); err != nil {
 		return nil, http.Header{}, err
 	}
 
+	if r.downloadLoggedOut() {
+		r.Logger.Info("Session has expired, logging in again")
+
+		if err := r.Login(); err != nil {
+			return nil, http.Header{}, err
+		}
+
+		if err := r.request(fullUrl, r.Browser.Open); err != nil {
+			return nil, http.Header{}, err
+		}
+
+		if r.downloadLoggedOut() {
+			return nil, http.Header{}, errors.New("Still logged out after logging in again")
+		}
+	}
+
 	b := &bytes.Buffer{}
 
 	if _, err := r.Browser.Download(b); err != nil {
@@ -481,3 +1455,14 @@ func (r *Runner) Download(u string) (io.ReadCloser, http.Header, error) {
 
 	return ioutil.NopCloser(bytes.NewReader(b.Bytes())), r.Browser.ResponseHeaders(), nil
 }
+
+// downloadLoggedOut checks whether a download was answered with the logged out page, a
+// downloaded file isn't html so it's never checked against the logged out selectors
+func (r *Runner) downloadLoggedOut() bool {
+	mediaType, _, err := mime.ParseMediaType(r.Browser.ResponseHeaders().Get("Content-Type"))
+	if err != nil || (mediaType != "text/html" && mediaType != "application/xhtml+xml") {
+		return false
+	}
+
+	return r.Definition.Search.LoggedOut.matchPage(r.Browser)
+}
@@ -1,8 +1,15 @@
 package indexer
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/cardigann/cardigann/config"
 	"github.com/cardigann/cardigann/torznab"
@@ -214,3 +221,1758 @@ func TestIndexerDefinitionRunner_Search(t *testing.T) {
 		t.Fatal("Incorrect peers count")
 	}
 }
+
+const exampleJSONDefinition = `
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      2:  Audio
+
+    modes:
+      search: q
+
+  search:
+    path: api/torrents.php
+    inputs:
+      search: "{{ .Query.Keywords }}"
+    response:
+      type: json
+    rows:
+      selector: data.torrents
+    fields:
+      category:
+        selector: category.id
+        filters:
+          - name: mapcats
+      title:
+        selector: name
+      details:
+        selector: urls.details
+      download:
+        selector: urls.download
+      size:
+        selector: size
+      date:
+        selector: added
+        filters:
+          - name: dateparse
+            args: 2006-01-02 15:04:05
+      seeders:
+        selector: peers.seeders
+      leechers:
+        selector: peers.leechers
+`
+
+const exampleJSONSearchPage = `
+{
+  "data": {
+    "torrents": [
+      {
+        "name": "Llama llama",
+        "category": {"id": 2},
+        "urls": {
+          "details": "details.php?id=309960",
+          "download": "/download/mma_llama_309960/mma_llama_309960_archive.torrent"
+        },
+        "size": "4GB",
+        "added": "2006-01-02 15:04:05",
+        "peers": {"seeders": 12, "leechers": 100}
+      }
+    ]
+  }
+}
+`
+
+func TestIndexerDefinitionRunner_SearchJSON(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(exampleJSONDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	httpmock.RegisterResponder("GET", "https://example.org/api/torrents.php", func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, exampleJSONSearchPage)
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := r.Search(torznab.Query{"q": "llamas", "cat": []int{torznab.CategoryAudio.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+
+	if results[0].Title != "Llama llama" {
+		t.Fatalf("Incorrect title %q", results[0].Title)
+	}
+
+	if results[0].Link != "https://example.org/download/mma_llama_309960/mma_llama_309960_archive.torrent" {
+		t.Fatal("Incorrect download link")
+	}
+
+	if results[0].Peers != 112 {
+		t.Fatal("Incorrect peers count")
+	}
+}
+
+const examplePagedDefinition = `
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      2:  Audio
+
+    modes:
+      search: q
+
+  search:
+    path: torrents.php
+    inputs:
+      search: "{{ .Query.Keywords }}"
+    paging:
+      pagesize: 1
+      pagestart: 1
+      inputs:
+        page: "{{ .Page }}"
+    rows:
+      selector: table.results tbody tr
+    fields:
+      title:
+        selector: td:nth-child(1) a
+      download:
+        selector: td:nth-child(1) a
+        attribute: href
+`
+
+const examplePagedSearchPage = `
+<html>
+<body>
+  <table class="results">
+    <tbody>
+      <tr>
+        <td><a href="/download/%s.torrent">Llama %s</a></td>
+      </tr>
+    </tbody>
+  </table>
+</body>
+</html>
+`
+
+func TestIndexerDefinitionRunner_SearchPaging(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(examplePagedDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	requestedPages := []string{}
+
+	httpmock.RegisterResponder("GET", "https://example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		page := req.URL.Query().Get("page")
+		if page != "" {
+			requestedPages = append(requestedPages, page)
+		}
+		resp := httpmock.NewStringResponse(http.StatusOK, fmt.Sprintf(examplePagedSearchPage, page, page))
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := r.Search(torznab.Query{"q": "llamas", "limit": 2, "offset": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	if results[0].Title != "Llama 2" || results[1].Title != "Llama 3" {
+		t.Fatalf("Unexpected results %q and %q", results[0].Title, results[1].Title)
+	}
+
+	if !reflect.DeepEqual(requestedPages, []string{"2", "3"}) {
+		t.Fatalf("Unexpected pages requested: %v", requestedPages)
+	}
+}
+
+func TestIndexerDefinitionRunner_SearchPagingFilteredRows(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      1: TV
+      2: Movies
+
+    modes:
+      search: q
+
+  search:
+    path: torrents.php
+    inputs:
+      search: "{{ .Keywords }}"
+    paging:
+      pagesize: 1
+      pagestart: 1
+      inputs:
+        page: "{{ .Page }}"
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+      category:
+        selector: td.cat
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	var requestedPages []string
+
+	// the first page only has movies, the next two have tv and then there are no more
+	httpmock.RegisterResponder("GET", "https://example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		page := req.URL.Query().Get("page")
+		if page != "" {
+			requestedPages = append(requestedPages, page)
+		}
+		body := `<table class="results"></table>`
+		switch page {
+		case "1":
+			body = `<table class="results"><tr><td class="cat">2000</td><td><a class="title" href="/1.torrent">Llama 1</a></td></tr></table>`
+		case "2", "3":
+			body = `<table class="results"><tr><td class="cat">5000</td><td><a class="title" href="/` + page + `.torrent">Llama ` + page + `</a></td></tr></table>`
+		}
+		resp := httpmock.NewStringResponse(http.StatusOK, body)
+		resp.Request = req
+		return resp, nil
+	})
+
+	for idx, row := range []struct {
+		query  torznab.Query
+		pages  []string
+		titles []string
+	}{
+		{torznab.Query{"q": "llamas", "cat": []int{torznab.CategoryTV.ID}, "limit": 5}, []string{"1", "2", "3", "4"}, []string{"Llama 2", "Llama 3"}},
+		{torznab.Query{"q": "llamas", "cat": []int{torznab.CategoryTV.ID}, "limit": 1, "offset": 1}, []string{"1", "2", "3"}, []string{"Llama 3"}},
+	} {
+		requestedPages = []string{}
+
+		results, err := r.Search(row.query)
+		if err != nil {
+			t.Fatalf("Row %d: %v", idx+1, err)
+		}
+
+		if !reflect.DeepEqual(requestedPages, row.pages) {
+			t.Fatalf("Row %d: Unexpected pages requested: %v", idx+1, requestedPages)
+		}
+
+		titles := []string{}
+		for _, result := range results {
+			titles = append(titles, result.Title)
+		}
+
+		if !reflect.DeepEqual(titles, row.titles) {
+			t.Fatalf("Row %d: Unexpected results %v", idx+1, titles)
+		}
+	}
+}
+
+func TestIndexerDefinitionRunner_LoginMethods(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"username": "myusername",
+			"password": "mypassword",
+			"cookie":   "uid=1234; pass=abcdef",
+			"url":      "https://example.org/",
+		},
+	}
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  login:
+    method: post
+    path: /takelogin.php
+    inputs:
+      username: "{{ .Config.username }}"
+      password: "{{ .Config.password }}"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpmock.RegisterResponder("POST", "https://example.org/takelogin.php", func(req *http.Request) (*http.Response, error) {
+		if user := req.FormValue("username"); user != "myusername" {
+			t.Fatalf("Incorrect username %q was provided", user)
+		}
+		resp := httpmock.NewStringResponse(http.StatusOK, "Success!")
+		resp.Request = req
+		return resp, nil
+	})
+
+	if err = NewRunner(def, conf).Login(); err != nil {
+		t.Fatal(err)
+	}
+
+	def, err = ParseDefinition([]byte(`
+---
+  site: example
+  login:
+    method: cookie
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewRunner(def, conf)
+	if err = r.Login(); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := r.currentURL()
+	if cookies := r.Browser.CookieJar().Cookies(u); len(cookies) != 2 {
+		t.Fatalf("Expected 2 cookies, got %d", len(cookies))
+	}
+
+	def, err = ParseDefinition([]byte(`
+---
+  site: example
+  login:
+    method: basic
+    inputs:
+      username: "{{ .Config.username }}"
+      password: "{{ .Config.password }}"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var auth string
+
+	httpmock.RegisterResponder("GET", "https://example.org/index.php", func(req *http.Request) (*http.Response, error) {
+		auth = req.Header.Get("Authorization")
+		resp := httpmock.NewStringResponse(http.StatusOK, "Welcome!")
+		resp.Request = req
+		return resp, nil
+	})
+
+	r = NewRunner(def, conf)
+	if err = r.Login(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = r.Browser.Open("https://example.org/index.php"); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := "Basic bXl1c2VybmFtZTpteXBhc3N3b3Jk"; auth != expected {
+		t.Fatalf("Expected basic auth header %q, got %q", expected, auth)
+	}
+
+	if _, err = ParseDefinition([]byte("login:\n  method: basic\n")); err == nil {
+		t.Fatal("Expected an error for basic login without username and password inputs")
+	}
+
+	if _, err = ParseDefinition([]byte("login:\n  method: llamas\n")); err == nil {
+		t.Fatal("Expected an error for an unknown login method")
+	}
+}
+
+func TestIndexerDefinitionRunner_PasskeyLogin(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      1: TV
+
+    modes:
+      search: q
+
+  settings:
+    - name: passkey
+      type: password
+      required: true
+
+  login:
+    method: passkey
+
+  search:
+    path: "rss/{{ .Config.passkey }}"
+    inputs:
+      search: "{{ .Keywords }}"
+      key: "{{ .Config.passkey }}"
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url":     "https://example.org/",
+			"passkey": "abc123",
+		},
+	}
+
+	var key string
+
+	httpmock.RegisterResponder("GET", "https://example.org/rss/abc123", func(req *http.Request) (*http.Response, error) {
+		key = req.URL.Query().Get("key")
+		resp := httpmock.NewStringResponse(http.StatusOK, `<table class="results">
+			<tr><td><a class="title" href="/download/1.torrent">Llamas</a></td></tr>
+		</table>`)
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := NewRunner(def, conf).Search(torznab.Query{"q": "llamas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key != "abc123" {
+		t.Fatalf("Expected the passkey to be sent, got %q", key)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+}
+
+type memoryCookieStore map[string][]*http.Cookie
+
+func (m memoryCookieStore) Load(site string) ([]*http.Cookie, error) {
+	return m[site], nil
+}
+
+func (m memoryCookieStore) Save(site string, cookies []*http.Cookie) error {
+	m[site] = cookies
+	return nil
+}
+
+func TestIndexerDefinitionRunner_LoginSkippedWithValidSession(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  settings:
+    - name: username
+      label: Username
+      type: text
+      required: true
+  login:
+    method: post
+    path: /takelogin.php
+    inputs:
+      username: "{{ .Config.username }}"
+    test:
+      path: /index.php
+      selector: a.logout
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"username": "myusername",
+			"url":      "https://example.org/",
+		},
+	}
+
+	logins := 0
+
+	httpmock.RegisterResponder("POST", "https://example.org/takelogin.php", func(req *http.Request) (*http.Response, error) {
+		logins++
+		resp := httpmock.NewStringResponse(http.StatusOK, "Success!")
+		resp.Header.Set("Set-Cookie", "session=llamas; Path=/; Max-Age=3600")
+		resp.Request = req
+		return resp, nil
+	})
+
+	httpmock.RegisterResponder("GET", "https://example.org/index.php", func(req *http.Request) (*http.Response, error) {
+		body := `<a href="/login.php">Login</a>`
+		if c, err := req.Cookie("session"); err == nil && c.Value == "llamas" {
+			body = `<a class="logout" href="/logout.php">Logout</a>`
+		}
+		resp := httpmock.NewStringResponse(http.StatusOK, body)
+		resp.Request = req
+		return resp, nil
+	})
+
+	store := memoryCookieStore{}
+
+	for i := 0; i < 2; i++ {
+		r := NewRunner(def, conf)
+		r.CookieStore = store
+		if err = r.Login(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if logins != 1 {
+		t.Fatalf("Expected 1 login, got %d", logins)
+	}
+
+	saved := store["example"]
+	if len(saved) != 1 || saved[0].Path != "/" || saved[0].Expires.IsZero() {
+		t.Fatalf("Expected the session cookie to be saved with its path and expiry, got %#v", saved)
+	}
+}
+
+func TestFileCookieStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cookies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &fileCookieStore{dir: dir}
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	err = store.Save("example", []*http.Cookie{
+		{Name: "session", Value: "llamas", Path: "/", Domain: "example.org", Expires: expires},
+		{Name: "expired", Value: "alpacas", Expires: time.Now().Add(-time.Hour)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies, err := store.Load("example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cookies) != 1 {
+		t.Fatalf("Expected expired cookies to be dropped, got %d cookies", len(cookies))
+	}
+
+	if c := cookies[0]; c.Name != "session" || c.Path != "/" || c.Domain != "example.org" || !c.Expires.Equal(expires) {
+		t.Fatalf("Expected cookie attributes to be kept, got %#v", c)
+	}
+}
+
+func TestIndexerDefinitionRunner_SearchRetriesAfterLogin(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  settings: []
+  login:
+    method: post
+    path: /takelogin.php
+  search:
+    path: torrents.php
+    loggedout:
+      selector: form#login
+    rows:
+      selector: table.results tbody tr
+    fields:
+      title:
+        selector: td:nth-child(1) a
+      download:
+        selector: td:nth-child(1) a
+        attribute: href
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	logins := 0
+
+	httpmock.RegisterResponder("POST", "https://example.org/takelogin.php", func(req *http.Request) (*http.Response, error) {
+		logins++
+		resp := httpmock.NewStringResponse(http.StatusOK, "Success!")
+		resp.Header.Set("Set-Cookie", "session=llamas; Path=/")
+		resp.Request = req
+		return resp, nil
+	})
+
+	httpmock.RegisterResponder("GET", "https://example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		body := `<form id="login"></form>`
+		if c, err := req.Cookie("session"); err == nil && c.Value == "llamas" {
+			body = fmt.Sprintf(examplePagedSearchPage, "1", "1")
+		}
+		resp := httpmock.NewStringResponse(http.StatusOK, body)
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := NewRunner(def, conf).Search(torznab.Query{"q": "llamas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if logins != 1 {
+		t.Fatalf("Expected 1 login, got %d", logins)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+}
+
+func TestIndexerDefinitionRunner_LoginRequiresSettings(t *testing.T) {
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  settings:
+    - name: apikey
+      label: API Key
+      type: text
+      required: true
+    - name: sort
+      label: Sort order
+      type: select
+      default: seeders
+      options:
+        seeders: Seeders
+        added: Date added
+  login:
+    method: passkey
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	if err = r.Login(); err == nil || err.Error() != `Missing required setting "apikey" for example` {
+		t.Fatalf("Expected a missing setting error, got %#v", err)
+	}
+
+	(*conf)["example"]["apikey"] = "llamas"
+
+	if err = r.Login(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := r.settings()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg["sort"] != "seeders" {
+		t.Fatalf("Expected default for sort setting, got %q", cfg["sort"])
+	}
+}
+
+func TestIndexerDefinitionRunner_MirrorFailover(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(examplePagedDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	def.Site = "mirrored"
+	def.Links = stringorslice{"https://down.example.org/", "https://mirror.example.org/"}
+
+	// working mirrors are remembered across runners, so start without one
+	activeMirrors.Lock()
+	delete(activeMirrors.sites, def.Site)
+	activeMirrors.Unlock()
+
+	conf := &config.ArrayConfig{
+		"mirrored": map[string]string{
+			"url": "https://blocked.example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	failedRequests := 0
+
+	for _, host := range []string{"blocked.example.org", "down.example.org"} {
+		httpmock.RegisterResponder("GET", "https://"+host+"/torrents.php", func(req *http.Request) (*http.Response, error) {
+			failedRequests++
+			return httpmock.NewStringResponse(http.StatusServiceUnavailable, "Down for maintenance"), nil
+		})
+		httpmock.RegisterResponder("GET", "https://"+host+"/", httpmock.NewStringResponder(http.StatusServiceUnavailable, ""))
+	}
+
+	httpmock.RegisterResponder("GET", "https://mirror.example.org/", httpmock.NewStringResponder(http.StatusOK, ""))
+	httpmock.RegisterResponder("GET", "https://mirror.example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		page := req.URL.Query().Get("page")
+		resp := httpmock.NewStringResponse(http.StatusOK, fmt.Sprintf(examplePagedSearchPage, page, page))
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := r.Search(torznab.Query{"q": "llamas", "limit": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].Link != "https://mirror.example.org/download/1.torrent" {
+		t.Fatalf("Expected a result from the working mirror, got %#v", results)
+	}
+
+	if failedRequests != 2 {
+		t.Fatalf("Expected 2 failed requests before failing over, got %d", failedRequests)
+	}
+
+	if _, err = r.Search(torznab.Query{"q": "llamas", "limit": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if failedRequests != 2 {
+		t.Fatalf("Expected the working mirror to be remembered, got %d failed requests", failedRequests)
+	}
+
+	statuses := r.CheckMirrors()
+	if len(statuses) != 3 {
+		t.Fatalf("Expected 3 mirror statuses, got %d", len(statuses))
+	}
+
+	if statuses[0].OK || statuses[1].OK || !statuses[2].OK {
+		t.Fatalf("Unexpected mirror statuses %#v", statuses)
+	}
+
+	if !reflect.DeepEqual(r.MirrorStatuses(), statuses) {
+		t.Fatalf("Expected the last mirror statuses to be kept, got %#v", r.MirrorStatuses())
+	}
+}
+
+const exampleOptionalFieldsDefinition = `
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      2:  Audio
+
+    modes:
+      search: q
+
+  search:
+    path: torrents.php
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+      comments:
+        selector: a.comments
+        attribute: data-href
+        optional: true
+      size:
+        selector: td.size
+        optional: true
+        default: 1 GB
+        filters:
+          - name: regexp
+            args: (\d+ GB)
+`
+
+const exampleOptionalFieldsSearchPage = `
+<html>
+<body>
+  <table class="results">
+    <tr>
+      <td><a class="title" href="/download/1.torrent">Llama 1</a></td>
+      <td><a class="comments" data-href="/comments/1">Comments</a></td>
+      <td class="size">2 GB</td>
+    </tr>
+    <tr>
+      <td><a class="title" href="/download/2.torrent">Llama 2</a></td>
+      <td><a class="comments">Comments</a></td>
+      <td class="size">Unknown</td>
+    </tr>
+  </table>
+</body>
+</html>
+`
+
+func TestIndexerDefinitionRunner_SearchOptionalFields(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(exampleOptionalFieldsDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	httpmock.RegisterResponder("GET", "https://example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, exampleOptionalFieldsSearchPage)
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := r.Search(torznab.Query{"q": "llamas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	if results[0].Comments != "https://example.org/comments/1" || results[0].Size != 2000000000 {
+		t.Fatalf("Unexpected first result %#v", results[0])
+	}
+
+	if results[1].Comments != "" || results[1].Size != 1000000000 {
+		t.Fatalf("Expected optional fields to fall back to defaults, got %#v", results[1])
+	}
+
+	def.Search.Fields["comments"] = selectorBlock{Selector: "a.comments", Attribute: "data-href"}
+
+	if _, err = r.Search(torznab.Query{"q": "llamas"}); err == nil {
+		t.Fatal("Expected a missing attribute on a required field to fail the search")
+	}
+}
+
+func TestParseIMDBId(t *testing.T) {
+	for idx, example := range []struct {
+		val      string
+		expected int
+	}{
+		{"tt0133093", 133093},
+		{"0133093", 133093},
+		{"http://www.imdb.com/title/tt0133093/", 133093},
+	} {
+		result, err := parseIMDBId(example.val)
+		if err != nil {
+			t.Fatalf("Row %d had an unexpected error: %s", idx+1, err.Error())
+		}
+		if result != example.expected {
+			t.Fatalf("Row %d was expecting %d, got %d", idx+1, example.expected, result)
+		}
+	}
+
+	if _, err := parseIMDBId("llamas"); err == nil {
+		t.Fatal("Expected an error for a value without an imdb id")
+	}
+}
+
+const exampleFreeleechDefinition = `
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      2:  Audio
+
+    modes:
+      search: q
+
+  search:
+    path: torrents.php
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+      downloadvolumefactor:
+        case:
+          img.freeleech: 0
+          img.halfleech: 0.5
+          "*": 1
+      uploadvolumefactor:
+        selector: img.double
+        case:
+          img.double: 2
+          "*": 1
+`
+
+const exampleFreeleechSearchPage = `
+<html>
+<body>
+  <table class="results">
+    <tr>
+      <td><a class="title" href="/download/1.torrent">Llama 1</a> <img class="freeleech"></td>
+    </tr>
+    <tr>
+      <td><a class="title" href="/download/2.torrent">Llama 2</a> <img class="halfleech"> <img class="double"></td>
+    </tr>
+    <tr>
+      <td><a class="title" href="/download/3.torrent">Llama 3</a></td>
+    </tr>
+  </table>
+</body>
+</html>
+`
+
+func formatVolumeFactor(f *float64) string {
+	if f == nil {
+		return "unset"
+	}
+	return fmt.Sprint(*f)
+}
+
+func TestIndexerDefinitionRunner_SearchVolumeFactors(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(exampleFreeleechDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	httpmock.RegisterResponder("GET", "https://example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, exampleFreeleechSearchPage)
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := r.Search(torznab.Query{"q": "llamas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+
+	for idx, expected := range [][2]string{{"0", "1"}, {"0.5", "2"}, {"1", "1"}} {
+		down, up := formatVolumeFactor(results[idx].DownloadVolumeFactor), formatVolumeFactor(results[idx].UploadVolumeFactor)
+		if down != expected[0] || up != expected[1] {
+			t.Fatalf("Result %d expected volume factors %v, got %s and %s", idx+1, expected, down, up)
+		}
+	}
+
+	results, err = r.Search(torznab.Query{"q": "llamas", "freeleech": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].Title != "Llama 1" {
+		t.Fatalf("Expected only the freeleech result, got %#v", results)
+	}
+
+	(*conf)["example"]["freeleech"] = "true"
+
+	results, err = r.Search(torznab.Query{"q": "llamas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("Expected the freeleech setting to filter results, got %d", len(results))
+	}
+}
+
+func TestIndexerDefinitionRunner_SearchMagnetLinks(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      2:  Audio
+
+    modes:
+      search: q
+
+  search:
+    path: torrents.php
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: td.name
+      download:
+        selector: a.magnet
+        attribute: href
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	magnet := "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=llamas"
+
+	httpmock.RegisterResponder("GET", "https://example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, `<table class="results"><tr>
+			<td class="name">Llamas</td><td><a class="magnet" href="`+magnet+`">Magnet</a></td>
+		</tr></table>`)
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := r.Search(torznab.Query{"q": "llamas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || results[0].Link != magnet || results[0].MagnetLink != magnet {
+		t.Fatalf("Expected the magnet link to be kept as is, got %#v", results)
+	}
+
+	if _, _, err = r.Download(magnet); err == nil {
+		t.Fatal("Expected an error downloading a magnet link")
+	}
+}
+
+const exampleCategoryLabelsDefinition = `
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      "TV/HD x264": TV/HD
+      "Music": Audio
+      7: Movies
+      "Movies/TV HD": [Movies/HD, TV/HD]
+
+    modes:
+      search: q
+
+  search:
+    path: torrents.php
+    inputs:
+      cat: "{{ range .Categories }}{{ . }}{{ end }}"
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+      category:
+        selector: img.category
+        attribute: alt
+        filters:
+          - name: mapcats
+`
+
+func TestIndexerDefinitionRunner_SearchCategoryLabels(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(exampleCategoryLabelsDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	requestedCats := []string{}
+
+	httpmock.RegisterResponder("GET", "https://example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		if cat := req.URL.Query().Get("cat"); cat != "" {
+			requestedCats = append(requestedCats, cat)
+		}
+		resp := httpmock.NewStringResponse(http.StatusOK, `<table class="results">
+			<tr><td><img class="category" alt="TV/HD x264"><a class="title" href="/download/1.torrent">Llamas S01E01</a></td></tr>
+			<tr><td><img class="category" alt="07"><a class="title" href="/download/2.torrent">Llamas the Movie</a></td></tr>
+			<tr><td><img class="category" alt="Movies/TV HD"><a class="title" href="/download/3.torrent">Llamas HD</a></td></tr>
+		</table>`)
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := r.Search(torznab.Query{"q": "llamas", "cat": []int{torznab.CategoryTV_HD.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 || !reflect.DeepEqual(results[0].Categories, []int{torznab.CategoryTV_HD.ID}) {
+		t.Fatalf("Expected TV/HD results, got %#v", results)
+	}
+
+	if !reflect.DeepEqual(results[1].Categories, []int{torznab.CategoryMovies_HD.ID, torznab.CategoryTV_HD.ID}) {
+		t.Fatalf("Expected a result in both Movies/HD and TV/HD, got %v", results[1].Categories)
+	}
+
+	if !reflect.DeepEqual(requestedCats, []string{"Movies/TV HDTV/HD x264"}) {
+		t.Fatalf("Expected the site category label in the search inputs, got %v", requestedCats)
+	}
+
+	results, err = r.Search(torznab.Query{"q": "llamas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 || !reflect.DeepEqual(results[1].Categories, []int{torznab.CategoryMovies.ID}) {
+		t.Fatalf("Expected numeric site categories to still be mapped, got %#v", results)
+	}
+}
+
+func TestIndexerDefinitionRunner_SearchConditionalInputs(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      1: TV
+      4: TV/HD
+
+    modes:
+      search: q
+      tv-search: [q, season, ep]
+
+  search:
+    path: torrents.php
+    inputs:
+      type: all
+      search:
+        value: "{{ .Query.q }}"
+        omitempty: true
+      season:
+        value: "{{ .Query.season }}"
+        if: "{{ .Query.season }}"
+      cat[]:
+        value: "{{ .Category }}"
+        percategory: true
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	var searchQuery url.Values
+
+	httpmock.RegisterResponder("GET", "https://example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		if req.URL.RawQuery != "" {
+			searchQuery = req.URL.Query()
+		}
+		resp := httpmock.NewStringResponse(http.StatusOK, `<table class="results"></table>`)
+		resp.Request = req
+		return resp, nil
+	})
+
+	for idx, example := range []struct {
+		query    torznab.Query
+		expected url.Values
+	}{
+		{torznab.Query{}, url.Values{"type": {"all"}}},
+		{torznab.Query{"q": "llamas", "season": "2"}, url.Values{"type": {"all"}, "search": {"llamas"}, "season": {"2"}}},
+		{torznab.Query{"q": "llamas", "cat": []int{torznab.CategoryTV.ID, torznab.CategoryTV_HD.ID}},
+			url.Values{"type": {"all"}, "search": {"llamas"}, "cat[]": {"1", "4"}}},
+	} {
+		searchQuery = nil
+		if _, err = r.Search(example.query); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(searchQuery, example.expected) {
+			t.Fatalf("Row %d expected search inputs %v, got %v", idx+1, example.expected, searchQuery)
+		}
+	}
+}
+
+func TestIndexerDefinitionRunner_SearchKeywordsFilters(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      1: TV
+
+    modes:
+      search: q
+      tv-search: [q, season, ep]
+
+  search:
+    path: torrents.php
+    keywordsfilters:
+      - name: re_replace
+        args: ["\\s*\\b(19|20)\\d{2}\\b", ""]
+      - name: replace
+        args: ["'", ""]
+      - name: replace
+        args: [" ", "."]
+      - name: tolower
+    inputs:
+      search: "{{ .Keywords }}"
+      original: "{{ .Query.Keywords }}"
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	var searchQuery url.Values
+
+	httpmock.RegisterResponder("GET", "https://example.org/torrents.php", func(req *http.Request) (*http.Response, error) {
+		if req.URL.RawQuery != "" {
+			searchQuery = req.URL.Query()
+		}
+		resp := httpmock.NewStringResponse(http.StatusOK, `<table class="results"></table>`)
+		resp.Request = req
+		return resp, nil
+	})
+
+	if _, err = r.Search(torznab.Query{"q": "Llama's Drama 2016", "season": "1", "ep": "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if searchQuery.Get("search") != "llamas.drama.s01e02" {
+		t.Fatalf("Expected filtered keywords, got %q", searchQuery.Get("search"))
+	}
+
+	if searchQuery.Get("original") != "Llama's Drama 2016 S01E02" {
+		t.Fatalf("Expected the query keywords to be unchanged, got %q", searchQuery.Get("original"))
+	}
+}
+
+func TestIndexerDefinitionRunner_SearchMethodsAndHeaders(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	for _, method := range []string{"post", "json-post"} {
+		def, err := ParseDefinition([]byte(`
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      1: TV
+
+    modes:
+      search: q
+
+  settings:
+    - name: apikey
+      type: text
+    - name: version
+      type: text
+      default: "2"
+
+  login:
+    method: passkey
+
+  search:
+    path: api/search
+    method: ` + method + `
+    headers:
+      X-Requested-With: XMLHttpRequest
+      X-Search: "{{ .Keywords }}"
+      X-Api-Key: "{{ .Config.apikey }}"
+      X-Api-Version: "{{ .Config.version }}"
+    inputs:
+      search: "{{ .Keywords }}"
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conf := &config.ArrayConfig{
+			"example": map[string]string{
+				"url":    "https://example.org/",
+				"apikey": "abc123",
+			},
+		}
+
+		r := NewRunner(def, conf)
+
+		var contentType, body, searchHeader, apiKeyHeader, apiVersionHeader string
+
+		httpmock.RegisterResponder("GET", "https://example.org/api/search", httpmock.NewStringResponder(http.StatusOK, ""))
+		httpmock.RegisterResponder("POST", "https://example.org/api/search", func(req *http.Request) (*http.Response, error) {
+			b, _ := ioutil.ReadAll(req.Body)
+			contentType, body = req.Header.Get("Content-Type"), string(b)
+			searchHeader = req.Header.Get("X-Search")
+			apiKeyHeader, apiVersionHeader = req.Header.Get("X-Api-Key"), req.Header.Get("X-Api-Version")
+			if req.Header.Get("X-Requested-With") != "XMLHttpRequest" {
+				return httpmock.NewStringResponse(http.StatusBadRequest, "Missing header"), nil
+			}
+			resp := httpmock.NewStringResponse(http.StatusOK, `<table class="results"></table>`)
+			resp.Request = req
+			return resp, nil
+		})
+
+		if _, err = r.Search(torznab.Query{"q": "llamas"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if searchHeader != "llamas" {
+			t.Fatalf("Expected templated search header for %s, got %q", method, searchHeader)
+		}
+
+		if apiKeyHeader != "abc123" || apiVersionHeader != "2" {
+			t.Fatalf("Expected config headers for %s, got %q and %q", method, apiKeyHeader, apiVersionHeader)
+		}
+
+		switch method {
+		case "post":
+			if contentType != "application/x-www-form-urlencoded" || body != "search=llamas" {
+				t.Fatalf("Unexpected form post %q with body %q", contentType, body)
+			}
+		case "json-post":
+			if contentType != "application/json" || body != `{"search":"llamas"}` {
+				t.Fatalf("Unexpected json post %q with body %q", contentType, body)
+			}
+		}
+	}
+}
+
+func TestIndexerDefinitionRunner_SearchCategoryPaths(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      1: TV
+      2: Movies
+
+    modes:
+      search: q
+
+  settings:
+    - name: apikey
+      type: text
+
+  login:
+    method: passkey
+
+  search:
+    paths:
+      - path: tv.php
+        categories: [1]
+      - path: movies.php
+        categories: Movies
+    inputs:
+      search: "{{ .Keywords }}"
+    rows:
+      selector: table.results tr
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+      category:
+        selector: td.cat
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	var searched []string
+
+	for path, cat := range map[string]string{"tv.php": "5000", "movies.php": "2000"} {
+		path, cat := path, cat
+		httpmock.RegisterResponder("GET", "https://example.org/"+path, func(req *http.Request) (*http.Response, error) {
+			if req.URL.RawQuery != "" {
+				searched = append(searched, path)
+			}
+			resp := httpmock.NewStringResponse(http.StatusOK, `<table class="results">
+				<tr><td class="cat">`+cat+`</td><td><a class="title" href="/shared.torrent">Shared</a></td></tr>
+				<tr><td class="cat">`+cat+`</td><td><a class="title" href="/`+path+`.torrent">`+path+`</a></td></tr>
+			</table>`)
+			resp.Request = req
+			return resp, nil
+		})
+	}
+
+	for idx, row := range []struct {
+		query    torznab.Query
+		searched []string
+		results  int
+	}{
+		{torznab.Query{"q": "llamas"}, []string{"tv.php", "movies.php"}, 3},
+		{torznab.Query{"q": "llamas", "cat": []int{torznab.CategoryTV.ID}}, []string{"tv.php"}, 2},
+		{torznab.Query{"q": "llamas", "cat": []int{torznab.CategoryMovies.ID}}, []string{"movies.php"}, 2},
+		{torznab.Query{"q": "llamas", "cat": []int{torznab.CategoryAudio.ID}}, []string{}, 0},
+		{torznab.Query{"q": "llamas", "offset": 2, "limit": 1}, []string{"tv.php", "movies.php"}, 1},
+		{torznab.Query{"q": "llamas", "offset": 1, "limit": 5}, []string{"tv.php", "movies.php"}, 2},
+		{torznab.Query{"q": "llamas", "offset": 3, "limit": 5}, []string{"tv.php", "movies.php"}, 0},
+	} {
+		searched = []string{}
+
+		results, err := r.Search(row.query)
+		if err != nil {
+			t.Fatalf("Row %d: %v", idx+1, err)
+		}
+
+		if !reflect.DeepEqual(searched, row.searched) {
+			t.Fatalf("Row %d: Expected paths %v to be searched, got %v", idx+1, row.searched, searched)
+		}
+
+		if len(results) != row.results {
+			t.Fatalf("Row %d: Expected %d results, got %d", idx+1, row.results, len(results))
+		}
+	}
+
+	_, err = ParseDefinition([]byte(`
+---
+  site: example
+  caps:
+    categories:
+      1: TV
+  search:
+    paths:
+      - path: tv.php
+        categories: Llamas
+`))
+	if err == nil {
+		t.Fatal("Expected an error for an unknown path category")
+	}
+}
+
+func TestIndexerDefinitionRunner_SearchMultiRowResults(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  links:
+    - http://www.example.org
+
+  caps:
+    categories:
+      1: TV
+
+    modes:
+      search: q
+
+  settings:
+    - name: apikey
+      type: text
+
+  login:
+    method: passkey
+
+  search:
+    path: search.php
+    inputs:
+      search: "{{ .Keywords }}"
+    rows:
+      selector: table.results tr
+      after: 1
+      exclude: tr.ad
+      dateheaders:
+        selector: tr.day
+        filters:
+          - name: dateparse
+            args: "2006-01-02"
+    fields:
+      title:
+        selector: a.title
+      download:
+        selector: a.title
+        attribute: href
+      size:
+        selector: td.size
+      date:
+        selector: td.added
+        optional: true
+        filters:
+          - name: dateparse
+            args: "2006-01-02"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	r := NewRunner(def, conf)
+
+	httpmock.RegisterResponder("GET", "https://example.org/search.php", func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, `<table class="results">
+			<tr class="day"><td>2016-10-02</td></tr>
+			<tr><td><a class="title" href="/1.torrent">First</a></td></tr>
+			<tr><td class="size">1 GB</td></tr>
+			<tr class="ad"><td>Buy llamas</td></tr>
+			<tr><td><a class="title" href="/2.torrent">Second</a></td></tr>
+			<tr><td class="size">2 GB</td><td class="added">2016-09-01</td></tr>
+			<tr class="day"><td>2016-10-01</td></tr>
+			<tr><td><a class="title" href="/3.torrent">Third</a></td></tr>
+			<tr><td class="size">3 GB</td></tr>
+		</table>`)
+		resp.Request = req
+		return resp, nil
+	})
+
+	results, err := r.Search(torznab.Query{"q": "llamas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+
+	for idx, row := range []struct {
+		title string
+		size  uint64
+		date  time.Time
+	}{
+		{"First", 1000000000, time.Date(2016, 10, 2, 0, 0, 0, 0, time.UTC)},
+		{"Second", 2000000000, time.Date(2016, 9, 1, 0, 0, 0, 0, time.UTC)},
+		{"Third", 3000000000, time.Date(2016, 10, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		if results[idx].Title != row.title {
+			t.Fatalf("Row %d: Expected title %q, got %q", idx+1, row.title, results[idx].Title)
+		}
+		if results[idx].Size != row.size {
+			t.Fatalf("Row %d: Expected size %d, got %d", idx+1, row.size, results[idx].Size)
+		}
+		if !results[idx].PublishDate.Equal(row.date) {
+			t.Fatalf("Row %d: Expected date %v, got %v", idx+1, row.date, results[idx].PublishDate)
+		}
+	}
+}
+
+func TestIndexerDefinitionRunner_DownloadRetriesAfterLogin(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	def, err := ParseDefinition([]byte(`
+---
+  site: example
+  settings: []
+  login:
+    method: post
+    path: /takelogin.php
+  search:
+    path: torrents.php
+    loggedout:
+      selector: form#login
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &config.ArrayConfig{
+		"example": map[string]string{
+			"url": "https://example.org/",
+		},
+	}
+
+	logins := 0
+
+	httpmock.RegisterResponder("POST", "https://example.org/takelogin.php", func(req *http.Request) (*http.Response, error) {
+		logins++
+		resp := httpmock.NewStringResponse(http.StatusOK, "Success!")
+		// the first session has already expired by the time of the download
+		resp.Header.Set("Set-Cookie", "session=stale; Path=/")
+		if logins > 1 {
+			resp.Header.Set("Set-Cookie", "session=llamas; Path=/")
+		}
+		resp.Request = req
+		return resp, nil
+	})
+
+	// the torrent itself contains the logged out markup, which mustn't be mistaken for the page
+	httpmock.RegisterResponder("GET", "https://example.org/download.php", func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, `<form id="login"></form>`)
+		resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+		if c, err := req.Cookie("session"); err == nil && c.Value == "llamas" {
+			resp = httpmock.NewStringResponse(http.StatusOK, `d7:comment24:<form id="login"></form>e`)
+			resp.Header.Set("Content-Type", "application/x-bittorrent")
+		}
+		resp.Request = req
+		return resp, nil
+	})
+
+	r := NewRunner(def, conf)
+
+	rc, _, err := r.Download("download.php")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	if logins != 2 {
+		t.Fatalf("Expected 2 logins, got %d", logins)
+	}
+
+	b, _ := ioutil.ReadAll(rc)
+	if !strings.HasPrefix(string(b), "d7:comment") {
+		t.Fatalf("Expected the torrent to be downloaded, got %q", b)
+	}
+}
@@ -1,11 +1,13 @@
 package indexer
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 )
 
 type filterBlock struct {
@@ -19,23 +21,76 @@ type selectorBlock struct {
 	Attribute string        `yaml:"attribute,omitempty"`
 	Remove    string        `yaml:"remove,omitempty"`
 	Filters   []filterBlock `yaml:"filters,omitempty"`
+	Case      caseBlock     `yaml:"case,omitempty"`
+	Optional  bool          `yaml:"optional,omitempty"`
+	Default   string        `yaml:"default,omitempty"`
+}
+
+const caseDefaultKey = "*"
+
+type caseEntry struct {
+	Key   string
+	Value string
+}
+
+// caseBlock maps selectors to the value a field takes when they match, in the order
+// they are declared. The "*" key matches when nothing else does.
+type caseBlock []caseEntry
+
+func (c *caseBlock) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var entries yaml.MapSlice
+	if err := unmarshal(&entries); err != nil {
+		return err
+	}
+
+	*c = caseBlock{}
+	for _, entry := range entries {
+		*c = append(*c, caseEntry{fmt.Sprint(entry.Key), fmt.Sprint(entry.Value)})
+	}
+
+	return nil
+}
+
+func (c caseBlock) MarshalYAML() (interface{}, error) {
+	entries := yaml.MapSlice{}
+	for _, entry := range c {
+		entries = append(entries, yaml.MapItem{Key: entry.Key, Value: entry.Value})
+	}
+	return entries, nil
+}
+
+// match returns the value of the first entry that matches, falling back to the "*" entry
+func (c caseBlock) match(matches func(key string) bool) (string, error) {
+	for _, entry := range c {
+		if entry.Key != caseDefaultKey && matches(entry.Key) {
+			return entry.Value, nil
+		}
+	}
+
+	for _, entry := range c {
+		if entry.Key == caseDefaultKey {
+			return entry.Value, nil
+		}
+	}
+
+	return "", errors.New("None of the case selectors matched")
 }
 
 func (s *selectorBlock) Match(selection *goquery.Selection) bool {
 	return selection.Find(s.Selector).Length() > 0
 }
 
-func (s *selectorBlock) Text(selection *goquery.Selection) (string, error) {
+func (s *selectorBlock) Text(ctx FilterContext, selection *goquery.Selection) (string, error) {
 	output := s.TextVal
 
 	if s.Selector != "" {
 		result := selection.Find(s.Selector)
 		if result.Length() == 0 {
-			return "", nil
+			return s.unmatchedText(ctx)
 		}
 
 		html, _ := result.Html()
-		filterLogger.
+		ctx.logger().
 			WithFields(logrus.Fields{"selector": s.Selector, "html": strings.TrimSpace(html)}).
 			Debugf("Selector matched %d elements", result.Length())
 
@@ -52,15 +107,87 @@ func (s *selectorBlock) Text(selection *goquery.Selection) (string, error) {
 			}
 			output = val
 		}
+
+		selection = result
+	}
+
+	if len(s.Case) > 0 {
+		var err error
+		output, err = s.Case.match(func(key string) bool {
+			return selection.Is(key) || selection.Find(key).Length() > 0
+		})
+		if err != nil {
+			return "", err
+		}
 	}
 
-	for _, f := range s.Filters {
-		filterLogger.
+	return s.applyFilters(ctx, output)
+}
+
+// JSONText resolves the selector as a path into decoded json data rather than as a css selector
+func (s *selectorBlock) JSONText(ctx FilterContext, data interface{}) (string, error) {
+	output := s.TextVal
+
+	if s.Selector != "" {
+		result, err := jsonPath(data, s.Selector)
+		if err == errJSONPathNotFound {
+			return s.unmatchedText(ctx)
+		} else if err != nil {
+			return "", err
+		}
+
+		ctx.logger().
+			WithFields(logrus.Fields{"selector": s.Selector, "value": result}).
+			Debugf("JSON path matched")
+
+		output, err = jsonString(result)
+		if err != nil {
+			return "", err
+		}
+		output = strings.TrimSpace(output)
+	}
+
+	// json values have no elements to match, so case keys are compared to the value instead
+	if len(s.Case) > 0 {
+		var err error
+		value := output
+		output, err = s.Case.match(func(key string) bool {
+			return key == value
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return s.applyFilters(ctx, output)
+}
+
+// unmatchedText is the text of a selector that matched nothing, which is the "*" case
+// when there is one and otherwise empty
+func (s *selectorBlock) unmatchedText(ctx FilterContext) (string, error) {
+	output, err := s.Case.match(func(key string) bool {
+		return false
+	})
+	if err != nil {
+		return "", nil
+	}
+
+	return s.applyFilters(ctx, output)
+}
+
+func (s *selectorBlock) applyFilters(ctx FilterContext, output string) (string, error) {
+	return applyFilterBlocks(ctx, s.Filters, output)
+}
+
+// applyFilterBlocks runs a value through each of the filters in turn
+func applyFilterBlocks(ctx FilterContext, filters []filterBlock, output string) (string, error) {
+	for _, f := range filters {
+		ctx.logger().
 			WithFields(logrus.Fields{"args": f.Args, "before": output}).
 			Debugf("Applying filter %s", f.Name)
 
 		var err error
-		output, err = invokeFilter(f.Name, f.Args, output)
+		output, err = invokeFilter(ctx, f.Name, f.Args, output)
 		if err != nil {
 			return "", err
 		}
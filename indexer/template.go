@@ -0,0 +1,166 @@
+package indexer
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// templateFuncs are the functions available to every template in a definition, such as
+// login and search inputs and the search path:
+//
+//	join "," .Categories          joins a list with a separator
+//	first .Categories             the first item of a list, or an empty string
+//	default "x" .Config.sort      the value, or the default when the value is empty
+//	replace " " "." .Query.q      replaces all occurrences of a string
+//	re_replace "\s+" "." .Query.q replaces all matches of a regular expression
+//	urlencode .Keywords           query escapes a string
+//	tolower / toupper / trim      changes case or removes surrounding whitespace
+//
+// These share their names and behaviour with the filters of the same name.
+// Missing values, such as a query param that wasn't given, are treated as empty strings.
+var templateFuncs = template.FuncMap{
+	"join":       templateJoin,
+	"first":      templateFirst,
+	"default":    templateDefault,
+	"replace":    templateReplace,
+	"re_replace": templateRegexpReplace,
+	"urlencode":  templateStringFunc(url.QueryEscape),
+	"tolower":    templateFilterFunc(filterToLower),
+	"toupper":    templateFilterFunc(filterToUpper),
+	"trim":       templateTrim,
+
+	templateValueFunc: templateValue,
+}
+
+// templateValueFunc is appended to every action that outputs a value, as templates
+// render a missing map value like .Query.q as "<no value>"
+const templateValueFunc = "_value"
+
+func parseTemplate(name, tpl string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(tpl)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			appendValueFunc(t.Tree, t.Tree.Root)
+		}
+	}
+
+	return tmpl, nil
+}
+
+// appendValueFunc pipes the output of each action in the tree through templateValue
+func appendValueFunc(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			appendValueFunc(tree, child)
+		}
+	case *parse.ActionNode:
+		// actions that declare variables don't output anything
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier(templateValueFunc).SetTree(tree).SetPos(n.Pos)},
+		})
+	case *parse.IfNode:
+		appendValueFunc(tree, n.List)
+		appendValueFunc(tree, n.ElseList)
+	case *parse.RangeNode:
+		appendValueFunc(tree, n.List)
+		appendValueFunc(tree, n.ElseList)
+	case *parse.WithNode:
+		appendValueFunc(tree, n.List)
+		appendValueFunc(tree, n.ElseList)
+	}
+}
+
+// templateValue renders a missing value as an empty string and leaves others as they are
+func templateValue(val interface{}) interface{} {
+	if val == nil {
+		return ""
+	}
+	return val
+}
+
+// templateString converts a value to a string, a missing value becomes an empty string
+func templateString(val interface{}) string {
+	if val == nil {
+		return ""
+	}
+	return fmt.Sprint(val)
+}
+
+// templateStringFunc adapts a string func to accept any value, including missing ones
+func templateStringFunc(fn func(string) string) func(interface{}) string {
+	return func(val interface{}) string {
+		return fn(templateString(val))
+	}
+}
+
+// templateFilterFunc adapts a filter func to accept any value, including missing ones
+func templateFilterFunc(fn func(string) (string, error)) func(interface{}) (string, error) {
+	return func(val interface{}) (string, error) {
+		return fn(templateString(val))
+	}
+}
+
+// templateItems converts a slice of any type to strings, a single value becomes a slice of one
+func templateItems(list interface{}) []string {
+	items := []string{}
+
+	v := reflect.ValueOf(list)
+	switch v.Kind() {
+	case reflect.Invalid:
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, fmt.Sprint(v.Index(i).Interface()))
+		}
+	default:
+		items = append(items, fmt.Sprint(list))
+	}
+
+	return items
+}
+
+func templateJoin(sep interface{}, list interface{}) string {
+	return strings.Join(templateItems(list), templateString(sep))
+}
+
+func templateFirst(list interface{}) string {
+	if items := templateItems(list); len(items) > 0 {
+		return items[0]
+	}
+	return ""
+}
+
+func templateDefault(def interface{}, val interface{}) string {
+	if s := templateString(val); s != "" {
+		return s
+	}
+	return templateString(def)
+}
+
+func templateReplace(old, new, s interface{}) (string, error) {
+	return filterReplace(templateString(old), templateString(new), templateString(s))
+}
+
+func templateRegexpReplace(pattern, replacement, s interface{}) (string, error) {
+	return filterRegexpReplace(templateString(pattern), templateString(replacement), templateString(s))
+}
+
+func templateTrim(s interface{}) (string, error) {
+	return filterTrim("", templateString(s))
+}
@@ -0,0 +1,56 @@
+package indexer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cardigann/cardigann/torznab"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	ctx := struct {
+		Query      torznab.Query
+		Categories []string
+		Config     map[string]string
+	}{
+		Query:      torznab.Query{"q": "Llama Drama", "season": "1", "ep": "2"},
+		Categories: []string{"2", "7"},
+		Config:     map[string]string{"sort": ""},
+	}
+
+	for idx, example := range []struct {
+		tpl, expected string
+	}{
+		{`{{ join "," .Categories }}`, "2,7"},
+		{`{{ first .Categories }}`, "2"},
+		{`{{ default "seeders" .Config.sort }}`, "seeders"},
+		{`{{ replace " " "." .Query.Keywords }}`, "Llama.Drama.S01E02"},
+		{`{{ re_replace "[aeiou]+" "" .Query.q }}`, "Llm Drm"},
+		{`{{ urlencode .Query.Keywords }}`, "Llama+Drama+S01E02"},
+		{`{{ .Query.q | tolower }}`, "llama drama"},
+		{`{{ toupper "llamas" }} {{ trim "  llamas  " }}`, "LLAMAS llamas"},
+		{`[{{ replace " " "." .Query.missing }}]`, "[]"},
+		{`[{{ re_replace " +" "." .Query.missing }}]`, "[]"},
+		{`[{{ urlencode .Query.missing }}]`, "[]"},
+		{`[{{ tolower .Query.missing }}{{ toupper .Query.missing }}{{ trim .Query.missing }}]`, "[]"},
+		{`[{{ .Query.missing | tolower }}]`, "[]"},
+		{`{{ default "seeders" .Query.missing }}`, "seeders"},
+		{`[{{ join "," .Query.missing }}{{ first .Query.missing }}]`, "[]"},
+		{`[{{ .Query.missing }}]`, "[]"},
+		{`{{ if .Query.q }}[{{ .Query.missing }}]{{ end }}{{ range .Categories }}{{ $.Query.missing }}{{ . }}{{ end }}`, "[]27"},
+		{`{{ $q := .Query.missing }}[{{ $q }}]`, "[]"},
+		{`{{ .Query.q }} <no value> {{ "<no value>" }}`, "Llama Drama <no value> <no value>"},
+	} {
+		tmpl, err := parseTemplate("test", example.tpl)
+		if err != nil {
+			t.Fatalf("Row %d failed to parse: %s", idx+1, err.Error())
+		}
+		b := &bytes.Buffer{}
+		if err = tmpl.Execute(b, ctx); err != nil {
+			t.Fatalf("Row %d failed to execute: %s", idx+1, err.Error())
+		}
+		if b.String() != example.expected {
+			t.Fatalf("Row %d was expecting %q, got %q", idx+1, example.expected, b.String())
+		}
+	}
+}
@@ -60,6 +60,7 @@ func run(args ...string) (exitCode int) {
 	configureDownloadCommand(app)
 	configureServerCommand(app)
 	configureTestDefinitionCommand(app)
+	configureLintCommand(app)
 
 	kingpin.MustParse(app.Parse(args))
 	return
@@ -76,7 +77,14 @@ func lookupIndexer(key string) (*indexer.Runner, error) {
 		return nil, err
 	}
 
-	return indexer.NewRunner(def, conf), nil
+	cookies, err := indexer.NewFileCookieStore()
+	if err != nil {
+		return nil, err
+	}
+
+	runner := indexer.NewRunner(def, conf)
+	runner.CookieStore = cookies
+	return runner, nil
 }
 
 func configureQueryCommand(app *kingpin.Application) {
@@ -233,6 +241,11 @@ func serverCommand(addr, port string, password string, devMode bool) error {
 		return err
 	}
 
+	cookies, err := indexer.NewFileCookieStore()
+	if err != nil {
+		return err
+	}
+
 	listenOn := fmt.Sprintf("%s:%s", addr, port)
 	log.WithFields(logrus.Fields{"bind": listenOn}).Info("Starting server")
 
@@ -240,6 +253,7 @@ func serverCommand(addr, port string, password string, devMode bool) error {
 		DevMode:    devMode,
 		Passphrase: password,
 		Config:     conf,
+		Cookies:    cookies,
 	}))
 }
 
@@ -271,7 +285,13 @@ func testDefinitionCommand(f *os.File) error {
 
 	fmt.Println("Definition file parsing OK")
 
+	cookies, err := indexer.NewFileCookieStore()
+	if err != nil {
+		return err
+	}
+
 	runner := indexer.NewRunner(def, conf)
+	runner.CookieStore = cookies
 	runner.Logger = log
 
 	err = runner.Login()
@@ -282,6 +302,15 @@ func testDefinitionCommand(f *os.File) error {
 	fmt.Println("Login OK")
 
 	err = runner.Test()
+
+	for _, mirror := range runner.MirrorStatuses() {
+		if mirror.OK {
+			fmt.Printf("Mirror %s OK\n", mirror.URL)
+		} else {
+			fmt.Printf("Mirror %s is down: %s\n", mirror.URL, mirror.Error)
+		}
+	}
+
 	if err != nil {
 		return fmt.Errorf("Test failed: %s", err.Error())
 	}
@@ -289,3 +318,40 @@ func testDefinitionCommand(f *os.File) error {
 	fmt.Println("Indexer test returned OK")
 	return nil
 }
+
+func configureLintCommand(app *kingpin.Application) {
+	var files []string
+
+	cmd := app.Command("lint", "Strictly validate yaml indexer definition files")
+
+	cmd.Arg("file", "The definition yaml files").
+		Required().
+		ExistingFilesVar(&files)
+
+	cmd.Action(func(c *kingpin.ParseContext) error {
+		return lintCommand(files)
+	})
+}
+
+func lintCommand(files []string) error {
+	failed := 0
+
+	for _, file := range files {
+		errs := indexer.LintDefinitionFile(file)
+		for _, e := range errs {
+			fmt.Printf("%s:%d: %s\n", file, e.Line, e.Message)
+		}
+
+		if len(errs) > 0 {
+			failed++
+		} else {
+			fmt.Printf("%s: OK\n", file)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d definitions failed linting", failed, len(files))
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@
 package server
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -36,6 +37,7 @@ type Params struct {
 	APIKey     []byte
 	Passphrase string
 	Config     config.Config
+	Cookies    indexer.CookieStore
 }
 
 type handler struct {
@@ -74,6 +76,7 @@ func NewHandler(p Params) http.Handler {
 	router.HandleFunc("/xhr/indexers/{indexer}/test", h.postIndexerTestHandler).Methods("POST")
 	router.HandleFunc("/xhr/indexers/{indexer}/config", h.getIndexersConfigHandler).Methods("GET")
 	router.HandleFunc("/xhr/indexers/{indexer}/config", h.patchIndexersConfigHandler).Methods("PATCH")
+	router.HandleFunc("/xhr/indexers/{indexer}/settings", h.getIndexerSettingsHandler).Methods("GET")
 	router.HandleFunc("/xhr/indexers", h.getIndexersHandler).Methods("GET")
 	router.HandleFunc("/xhr/indexers", h.patchIndexersHandler).Methods("PATCH")
 	router.HandleFunc("/xhr/auth", h.postAuthHandler).Methods("POST")
@@ -93,13 +96,16 @@ func (h *handler) baseURL(r *http.Request, path string) (*url.URL, error) {
 	return url.Parse(fmt.Sprintf("%s://%s%s", proto, r.Host, path))
 }
 
-func (h *handler) lookupIndexer(key string) (torznab.Indexer, error) {
+func (h *handler) lookupIndexer(ctx context.Context, key string) (torznab.Indexer, error) {
 	def, err := indexer.LoadDefinition(key)
 	if err != nil {
 		return nil, err
 	}
 
-	return indexer.NewRunner(def, h.Params.Config), nil
+	runner := indexer.NewRunner(def, h.Params.Config)
+	runner.CookieStore = h.Params.Cookies
+	runner.Context = ctx
+	return runner, nil
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -129,7 +135,7 @@ func (h *handler) torznabHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	indexer, err := h.lookupIndexer(indexerID)
+	i, err := h.lookupIndexer(r.Context(), indexerID)
 	if err != nil {
 		torznab.Error(w, err.Error(), torznab.ErrIncorrectParameter)
 		return
@@ -144,10 +150,10 @@ func (h *handler) torznabHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch t {
 	case "caps":
-		indexer.Capabilities().ServeHTTP(w, r)
+		i.Capabilities().ServeHTTP(w, r)
 
 	case "search", "tvsearch", "tv-search":
-		feed, err := h.search(r, indexer, indexerID)
+		feed, err := h.search(r, i, indexerID)
 		if err != nil {
 			torznab.Error(w, err.Error(), torznab.ErrUnknownError)
 			return
@@ -182,13 +188,18 @@ func (h *handler) downloadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	indexer, err := h.lookupIndexer(t.Site)
+	if indexer.IsMagnetLink(t.Link) {
+		http.Redirect(w, r, t.Link, http.StatusFound)
+		return
+	}
+
+	i, err := h.lookupIndexer(r.Context(), t.Site)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
 
-	rc, headers, err := indexer.Download(t.Link)
+	rc, headers, err := i.Download(t.Link)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
@@ -203,7 +214,7 @@ func (h *handler) downloadHandler(w http.ResponseWriter, r *http.Request) {
 	go io.Copy(w, rc)
 }
 
-func (h *handler) search(r *http.Request, indexer torznab.Indexer, siteKey string) (*torznab.ResultFeed, error) {
+func (h *handler) search(r *http.Request, i torznab.Indexer, siteKey string) (*torznab.ResultFeed, error) {
 	baseURL, err := h.baseURL(r, "/download")
 	if err != nil {
 		return nil, err
@@ -214,13 +225,13 @@ func (h *handler) search(r *http.Request, indexer torznab.Indexer, siteKey strin
 		return nil, err
 	}
 
-	items, err := indexer.Search(query)
+	items, err := i.Search(query)
 	if err != nil {
 		return nil, err
 	}
 
 	feed := &torznab.ResultFeed{
-		Info:  indexer.Info(),
+		Info:  i.Info(),
 		Items: items,
 	}
 
@@ -229,8 +240,12 @@ func (h *handler) search(r *http.Request, indexer torznab.Indexer, siteKey strin
 		return nil, err
 	}
 
-	// rewrite links to use the server
+	// rewrite links to use the server, magnet links are left as they are
 	for idx, item := range feed.Items {
+		if indexer.IsMagnetLink(item.Link) {
+			continue
+		}
+
 		t := &token{
 			Site: item.Site,
 			Link: item.Link,
@@ -240,8 +255,9 @@ func (h *handler) search(r *http.Request, indexer torznab.Indexer, siteKey strin
 		if err != nil {
 			return nil, err
 		}
-		baseURL.Path += fmt.Sprintf("/%s/%s.torrent", te, item.Title)
-		feed.Items[idx].Link = baseURL.String()
+		u := *baseURL
+		u.Path += fmt.Sprintf("/%s/%s.torrent", te, item.Title)
+		feed.Items[idx].Link = u.String()
 	}
 
 	return feed, err
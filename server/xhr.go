@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -32,7 +33,7 @@ func (h *handler) loadIndexerViews(baseURL string) ([]indexerView, error) {
 
 	reply := []indexerView{}
 	for _, indexerID := range defs {
-		i, err := h.lookupIndexer(indexerID)
+		i, err := h.lookupIndexer(context.Background(), indexerID)
 		if err == indexer.ErrUnknownIndexer {
 			log.Printf("Unknown indexer %q in configuration", indexerID)
 			continue
@@ -87,19 +88,31 @@ func (h *handler) getIndexersConfigHandler(w http.ResponseWriter, r *http.Reques
 	params := mux.Vars(r)
 	indexerID := params["indexer"]
 
-	config, err := h.Params.Config.Section(indexerID)
+	def, err := indexer.LoadDefinition(indexerID)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	section, err := h.Params.Config.Section(indexerID)
 	if err != nil {
 		jsonError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	if _, ok := config["url"]; !ok {
-		i, err := h.lookupIndexer(indexerID)
-		if err != nil {
-			jsonError(w, err.Error(), http.StatusNotFound)
-			return
+	config := map[string]string{}
+	for k, v := range section {
+		config[k] = v
+	}
+
+	if _, ok := config["url"]; !ok && len(def.Links) > 0 {
+		config["url"] = def.Links[0]
+	}
+
+	for _, setting := range def.Settings {
+		if _, ok := config[setting.Name]; !ok && setting.Default != "" {
+			config[setting.Name] = setting.Default
 		}
-		config["url"] = i.Info().Link
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
@@ -108,6 +121,27 @@ func (h *handler) getIndexersConfigHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+func (h *handler) getIndexerSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.checkRequestAuthorized(r) {
+		jsonError(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
+
+	params := mux.Vars(r)
+	indexerID := params["indexer"]
+
+	def, err := indexer.LoadDefinition(indexerID)
+	if err != nil {
+		jsonError(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(def.Settings); err != nil {
+		panic(err)
+	}
+}
+
 func (h *handler) patchIndexersConfigHandler(w http.ResponseWriter, r *http.Request) {
 	if !h.checkRequestAuthorized(r) {
 		jsonError(w, "Not Authorized", http.StatusUnauthorized)
@@ -142,7 +176,7 @@ func (h *handler) postIndexerTestHandler(w http.ResponseWriter, r *http.Request)
 	params := mux.Vars(r)
 	indexerID := params["indexer"]
 
-	i, err := h.lookupIndexer(indexerID)
+	i, err := h.lookupIndexer(r.Context(), indexerID)
 	if err != nil {
 		jsonError(w, "Not Found", http.StatusNotFound)
 		return
@@ -151,10 +185,17 @@ func (h *handler) postIndexerTestHandler(w http.ResponseWriter, r *http.Request)
 	err = i.Test()
 
 	var resp = struct {
-		OK    bool   `json:"ok"`
-		Error string `json:"error,omitempty"`
+		OK      bool                   `json:"ok"`
+		Error   string                 `json:"error,omitempty"`
+		Mirrors []indexer.MirrorStatus `json:"mirrors,omitempty"`
 	}{}
 
+	if m, ok := i.(interface {
+		MirrorStatuses() []indexer.MirrorStatus
+	}); ok {
+		resp.Mirrors = m.MirrorStatuses()
+	}
+
 	if err != nil {
 		resp.Error = err.Error()
 	} else {
@@ -0,0 +1,33 @@
+package torznab
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestCapabilitiesWithSharedCategories(t *testing.T) {
+	caps := Capabilities{
+		Categories: CategoryMapping{
+			"1": Categories{CategoryMovies_HD, CategoryTV_HD},
+			"2": Categories{CategoryTV_HD},
+		},
+	}
+
+	x, err := xml.Marshal(caps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := strings.Count(string(x), `<category id="5040"`); n != 1 {
+		t.Fatalf("Expected TV/HD to be listed once, got %d times in %s", n, x)
+	}
+
+	if !strings.Contains(string(x), `<category id="2040"`) {
+		t.Fatalf("Expected Movies/HD to be listed in %s", x)
+	}
+
+	if cats := caps.Categories.ReverseMap([]int{CategoryTV_HD.ID}); len(cats) != 2 {
+		t.Fatalf("Expected both site categories for TV/HD, got %v", cats)
+	}
+}
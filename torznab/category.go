@@ -1,5 +1,11 @@
 package torznab
 
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
 type Category struct {
 	ID   int
 	Name string
@@ -149,32 +155,58 @@ var AllCategories = []Category{
 	CategoryBooks_Unknown,
 }
 
-type CategoryMapping map[int]Category
+// CategoryMapping maps the categories of a site, by the id or label the site uses for
+// them, to one or more torznab categories
+type CategoryMapping map[string]Categories
+
+// Lookup returns the torznab categories for a site category, numeric ids are matched
+// regardless of leading zeros
+func (mapping CategoryMapping) Lookup(siteCat string) (Categories, bool) {
+	if cats, ok := mapping[siteCat]; ok {
+		return cats, true
+	}
+
+	if id, err := strconv.Atoi(strings.TrimSpace(siteCat)); err == nil {
+		cats, ok := mapping[strconv.Itoa(id)]
+		return cats, ok
+	}
+
+	return nil, false
+}
 
 func (mapping CategoryMapping) Categories() Categories {
 	cats := Categories{}
 	added := map[int]bool{}
 
-	for _, c := range mapping {
-		if _, exists := added[c.ID]; exists {
-			continue
+	for _, mapped := range mapping {
+		for _, c := range mapped {
+			if _, exists := added[c.ID]; exists {
+				continue
+			}
+			cats = append(cats, c)
+			added[c.ID] = true
 		}
-		cats = append(cats, c)
-		added[c.ID] = true
 	}
 
 	return cats
 }
 
-func (mapping CategoryMapping) ReverseMap(cats []int) []int {
-	results := []int{}
+// ReverseMap returns the site categories that map to the given torznab category ids
+func (mapping CategoryMapping) ReverseMap(cats []int) []string {
+	results := []string{}
 
 	for _, unmapped := range cats {
-		for localID, cat := range mapping {
-			if cat.ID == unmapped {
-				results = append(results, localID)
+		localIDs := []string{}
+		for localID, mapped := range mapping {
+			for _, cat := range mapped {
+				if cat.ID == unmapped {
+					localIDs = append(localIDs, localID)
+					break
+				}
 			}
 		}
+		sort.Strings(localIDs)
+		results = append(results, localIDs...)
 	}
 
 	return results
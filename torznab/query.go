@@ -47,8 +47,21 @@ func ParseQuery(v url.Values) (Query, error) {
 		case "t":
 			continue
 
-		case "q", "ep", "season", "apikey", "offset", "limit", "extended":
+		case "q", "ep", "season", "apikey", "extended":
 			query[k] = vals[0]
+		case "offset", "limit":
+			n, err := strconv.Atoi(vals[0])
+			if err != nil {
+				return Query{}, fmt.Errorf("Unable to parse %s %q", k, vals[0])
+			}
+			query[k] = n
+
+		case "freeleech":
+			b, err := strconv.ParseBool(vals[0])
+			if err != nil {
+				return Query{}, fmt.Errorf("Unable to parse %s %q", k, vals[0])
+			}
+			query[k] = b
 
 		case "cat":
 			catInts, err := splitInts(vals[0], ",")
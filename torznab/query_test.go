@@ -26,3 +26,22 @@ func TestParsingQueryKeywords(t *testing.T) {
 		}
 	}
 }
+
+func TestParsingQueryLimitAndOffset(t *testing.T) {
+	q, err := ParseQuery(url.Values{"q": []string{"llamas"}, "limit": []string{"100"}, "offset": []string{"50"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limit, ok := q["limit"].(int); !ok || limit != 100 {
+		t.Fatalf("Expected limit of 100, got %#v", q["limit"])
+	}
+
+	if offset, ok := q["offset"].(int); !ok || offset != 50 {
+		t.Fatalf("Expected offset of 50, got %#v", q["offset"])
+	}
+
+	if _, err := ParseQuery(url.Values{"limit": []string{"lots"}}); err == nil {
+		t.Fatal("Expected an error for a non-numeric limit")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,7 +17,7 @@ type ResultItem struct {
 	GUID        string
 	Comments    string
 	Link        string
-	Category    int
+	Categories  []int
 	Size        uint64
 	PublishDate time.Time
 
@@ -24,6 +25,61 @@ type ResultItem struct {
 	Peers           int
 	MinimumRatio    float64
 	MinimumSeedTime time.Duration
+
+	// volume factors are nil when the site doesn't say, which clients take as 1
+	DownloadVolumeFactor *float64
+	UploadVolumeFactor   *float64
+
+	InfoHash   string
+	MagnetLink string
+	Grabs      int
+	Files      int
+	IMDB       int
+	TVDBId     int
+	TVRageId   int
+	Banner     string
+	Poster     string
+}
+
+func formatFactor(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+// extraAttrs returns the optional torznab attributes that have values
+func (ri ResultItem) extraAttrs() []torznabAttrView {
+	attrs := []torznabAttrView{}
+
+	for _, attr := range []struct {
+		name  string
+		value string
+		isSet bool
+	}{
+		{"downloadvolumefactor", formatFactor(ri.DownloadVolumeFactor), ri.DownloadVolumeFactor != nil},
+		{"uploadvolumefactor", formatFactor(ri.UploadVolumeFactor), ri.UploadVolumeFactor != nil},
+		{"infohash", ri.InfoHash, ri.InfoHash != ""},
+		{"magneturl", ri.MagnetLink, ri.MagnetLink != ""},
+		{"grabs", strconv.Itoa(ri.Grabs), ri.Grabs > 0},
+		{"files", strconv.Itoa(ri.Files), ri.Files > 0},
+		{"imdb", fmt.Sprintf("%07d", ri.IMDB), ri.IMDB > 0},
+		{"tvdbid", strconv.Itoa(ri.TVDBId), ri.TVDBId > 0},
+		{"rageid", strconv.Itoa(ri.TVRageId), ri.TVRageId > 0},
+		{"bannerurl", ri.Banner, ri.Banner != ""},
+		{"coverurl", ri.Poster, ri.Poster != ""},
+	} {
+		if attr.isSet {
+			attrs = append(attrs, torznabAttrView{Name: attr.name, Value: attr.value})
+		}
+	}
+
+	return attrs
+}
+
+// IsMagnetLink returns whether a result link is a magnet link rather than a url to download
+func IsMagnetLink(link string) bool {
+	return strings.HasPrefix(link, "magnet:")
 }
 
 func (ri ResultItem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
@@ -37,6 +93,15 @@ func (ri ResultItem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 		Type:   "application/x-bittorrent",
 	}
 
+	if IsMagnetLink(ri.Link) {
+		enclosure.Type = "application/x-bittorrent;x-scheme-handler/magnet"
+	}
+
+	categories := []string{}
+	for _, cat := range ri.Categories {
+		categories = append(categories, strconv.Itoa(cat))
+	}
+
 	var itemView = struct {
 		XMLName struct{} `xml:"item"`
 
@@ -46,7 +111,7 @@ func (ri ResultItem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 		GUID        string      `xml:"guid,omitempty"`
 		Comments    string      `xml:"comments,omitempty"`
 		Link        string      `xml:"link,omitempty"`
-		Category    string      `xml:"category,omitempty"`
+		Category    []string    `xml:"category,omitempty"`
 		PublishDate string      `xml:"pubDate,omitempty"`
 		Enclosure   interface{} `xml:"enclosure,omitempty"`
 
@@ -58,7 +123,7 @@ func (ri ResultItem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 		GUID:        ri.GUID,
 		Comments:    ri.Comments,
 		Link:        ri.Link,
-		Category:    strconv.Itoa(ri.Category),
+		Category:    categories,
 		PublishDate: ri.PublishDate.Format(rfc822),
 		Enclosure:   enclosure,
 		Attrs: []torznabAttrView{
@@ -70,6 +135,12 @@ func (ri ResultItem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 		},
 	}
 
+	for _, cat := range categories {
+		itemView.Attrs = append(itemView.Attrs, torznabAttrView{Name: "category", Value: cat})
+	}
+
+	itemView.Attrs = append(itemView.Attrs, ri.extraAttrs()...)
+
 	e.Encode(itemView)
 	return nil
 }
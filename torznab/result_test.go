@@ -0,0 +1,68 @@
+package torznab
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestResultItemExtendedAttrs(t *testing.T) {
+	item := ResultItem{
+		Title:      "Llamas",
+		InfoHash:   "0123456789abcdef0123456789abcdef01234567",
+		MagnetLink: "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567",
+		Grabs:      12,
+		IMDB:       133093,
+		TVDBId:     81189,
+	}
+
+	x, err := xml.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`<torznab:attr name="infohash" value="0123456789abcdef0123456789abcdef01234567"></torznab:attr>`,
+		`<torznab:attr name="magneturl" value="magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567"></torznab:attr>`,
+		`<torznab:attr name="grabs" value="12"></torznab:attr>`,
+		`<torznab:attr name="imdb" value="0133093"></torznab:attr>`,
+		`<torznab:attr name="tvdbid" value="81189"></torznab:attr>`,
+	} {
+		if !strings.Contains(string(x), expected) {
+			t.Fatalf("Expected %s in %s", expected, x)
+		}
+	}
+
+	for _, unexpected := range []string{`name="files"`, `name="rageid"`, `name="coverurl"`} {
+		if strings.Contains(string(x), unexpected) {
+			t.Fatalf("Didn't expect %s in %s", unexpected, x)
+		}
+	}
+}
+
+func TestResultItemVolumeFactors(t *testing.T) {
+	x, err := xml.Marshal(ResultItem{Title: "Llamas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(x), "volumefactor") {
+		t.Fatalf("Didn't expect volume factors for an item without them in %s", x)
+	}
+
+	free, double := 0.0, 2.0
+
+	x, err = xml.Marshal(ResultItem{Title: "Llamas", DownloadVolumeFactor: &free, UploadVolumeFactor: &double})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`<torznab:attr name="downloadvolumefactor" value="0"></torznab:attr>`,
+		`<torznab:attr name="uploadvolumefactor" value="2"></torznab:attr>`,
+	} {
+		if !strings.Contains(string(x), expected) {
+			t.Fatalf("Expected %s in %s", expected, x)
+		}
+	}
+}
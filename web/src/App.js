@@ -55,13 +55,13 @@ class App extends Component {
     });
   }
   handleAddIndexer = (selected) => {
-    this.loadIndexerConfig(selected, (config) => {
-      this.showConfigModal(selected, config);
+    this.loadIndexerConfig(selected, (data) => {
+      this.showConfigModal(selected, data.config, data.settings);
     });
   }
   handleEditIndexer = (selected, afterFunc) => {
-    this.loadIndexerConfig(selected, (config) => {
-      this.showConfigModal(selected, config, afterFunc);
+    this.loadIndexerConfig(selected, (data) => {
+      this.showConfigModal(selected, data.config, data.settings, afterFunc);
     });
   }
   handleTestIndexer = (indexer, afterFunc) => {
@@ -92,15 +92,18 @@ class App extends Component {
     });
   }
   loadIndexerConfig = (indexer, dataFunc) => {
-    fetch("/xhr/indexers/"+indexer.id+"/config", {
+    let opts = {
         headers: {
           'Accept': 'application/json',
           'Content-Type': 'application/json',
           'Authorization': 'apitoken ' + this.state.apiKey,
         },
-    })
-    .then((response) => response.json())
-    .then(dataFunc)
+    };
+    Promise.all([
+      fetch("/xhr/indexers/"+indexer.id+"/config", opts).then((response) => response.json()),
+      fetch("/xhr/indexers/"+indexer.id+"/settings", opts).then((response) => response.json()),
+    ])
+    .then(([config, settings]) => dataFunc({config: config, settings: settings}))
   }
   loadIndexers = () => {
     if (!this.state.apiKey) {
@@ -132,9 +135,9 @@ class App extends Component {
       this.setState({errorMessage: err.message, errorScope: "loading indexers"})
     });
   }
-  showConfigModal = (indexer, config, afterFunc) => {
+  showConfigModal = (indexer, config, settings, afterFunc) => {
     this.setState({
-      configure: <ConfigModal config={config} indexer={indexer} show={true}
+      configure: <ConfigModal config={config} settings={settings} indexer={indexer} show={true}
         onClose={() => {
           this.setState({configure: null});
           afterFunc();
@@ -1,6 +1,6 @@
 import React, { Component } from 'react';
 import ReactDOM from 'react-dom';
-import { Col, Modal, Button, Form, FormGroup, FormControl, ControlLabel} from 'react-bootstrap';
+import { Col, Modal, Button, Checkbox, Form, FormGroup, FormControl, ControlLabel} from 'react-bootstrap';
 
 class ConfigModal extends Component {
   static defaultProps = {
@@ -10,6 +10,7 @@ class ConfigModal extends Component {
   static propTypes = {
    indexer: React.PropTypes.object.isRequired,
    config: React.PropTypes.object.isRequired,
+   settings: React.PropTypes.array.isRequired,
   }
   state = {
     config: this.props.config,
@@ -26,12 +27,52 @@ class ConfigModal extends Component {
     this.setState({show: false});
   }
   handleSave = () => {
-    this.props.onSave(this.props.indexer, {
+    let config = {
       url: ReactDOM.findDOMNode(this.refs.url).value,
-      username: ReactDOM.findDOMNode(this.refs.username).value,
-      password: ReactDOM.findDOMNode(this.refs.password).value,
       enabled: "true"
-    }, () => this.setState({show: false}));
+    };
+    this.props.settings.forEach((setting) => {
+      let node = ReactDOM.findDOMNode(this.refs["setting_" + setting.name]);
+      if (setting.type === "checkbox") {
+        config[setting.name] = node.querySelector("input").checked ? "true" : "false";
+      } else {
+        config[setting.name] = node.value;
+      }
+    });
+    this.props.onSave(this.props.indexer, config, () => this.setState({show: false}));
+  }
+  renderSetting = (setting) => {
+    let value = this.state.config[setting.name];
+    let ref = "setting_" + setting.name;
+    let control;
+
+    switch (setting.type) {
+      case "checkbox":
+        control = <Checkbox defaultChecked={value === "true"} ref={ref} />;
+        break;
+      case "select":
+        control = (
+          <FormControl componentClass="select" defaultValue={value} ref={ref}>
+            {Object.keys(setting.options).map((key) =>
+              <option key={key} value={key}>{setting.options[key]}</option>
+            )}
+          </FormControl>
+        );
+        break;
+      default:
+        control = <FormControl type={setting.type} placeholder={setting.label} defaultValue={value} ref={ref} />;
+    }
+
+    return (
+      <FormGroup controlId={"formHorizontalSetting_" + setting.name} key={setting.name}>
+        <Col componentClass={ControlLabel} sm={2}>
+          {setting.label}
+        </Col>
+        <Col sm={10}>
+          {control}
+        </Col>
+      </FormGroup>
+    );
   }
   render() {
     return (
@@ -49,22 +90,7 @@ class ConfigModal extends Component {
                 <FormControl type="text" placeholder="URL" defaultValue={this.state.config.url} ref="url" />
               </Col>
             </FormGroup>
-            <FormGroup controlId="formHorizontalUsername">
-              <Col componentClass={ControlLabel} sm={2}>
-                Username
-              </Col>
-              <Col sm={10}>
-                <FormControl type="username" placeholder="Username" defaultValue={this.state.config.username} ref="username" />
-              </Col>
-            </FormGroup>
-            <FormGroup controlId="formHorizontalPassword">
-              <Col componentClass={ControlLabel} sm={2}>
-                Password
-              </Col>
-              <Col sm={10}>
-                <FormControl type="password" placeholder="Password" defaultValue={this.state.config.password} ref="password" />
-              </Col>
-            </FormGroup>
+            {this.props.settings.map(this.renderSetting)}
           </Form>
         </Modal.Body>
         <Modal.Footer>